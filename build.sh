@@ -14,7 +14,7 @@ if [ "$1" = "--help" ] || [ "$1" = "-h" ]; then
     echo "  uninstall     Uninstall DML from system-wide location (requires sudo)"
     echo "  uninstall local Uninstall DML from user's ~/.local directory"
     echo "  test          Run all tests"
-    echo "  test-nomathjax Run tests that don't require LaTeX/ImageMagick"
+    echo "  test-nomathjax Run tests that don't require LaTeX"
     echo "  help          Show this help message"
     exit 0
 fi
@@ -26,6 +26,9 @@ cd "$(dirname "$0")"
 if [ "$1" = "test" ]; then
     echo "Running all tests..."
     go test -v ./...
+    # The renderer is shared by the output workers
+    echo "Running LaTeX renderer tests with the race detector..."
+    go test -race ./internal/latex
     exit $?
 fi
 
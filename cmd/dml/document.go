@@ -0,0 +1,91 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"dml/internal/latex"
+	"dml/internal/regex"
+)
+
+// document is what earlier parts of the input tell later ones: the macros
+// defined so far and the equations numbered and labelled so far. It is
+// updated as the input is read, in order, and the results are captured when
+// a render is queued, so renders running in parallel each see the state at
+// their own position in the input.
+type document struct {
+	macros    latex.Macros
+	equations latex.Equations
+}
+
+// newDocument returns a document at the start of the input. Unless unsafe
+// is set, macro definitions that safe mode forbids are not recorded and stay
+// in their expressions, so that only those expressions fail to render.
+func newDocument(unsafe bool) *document {
+	return &document{macros: latex.Macros{Unsafe: unsafe}}
+}
+
+// inlineDelimiters are the two forms of inline math, with their delimiters
+var inlineDelimiters = []struct {
+	pattern     *regexp.Regexp
+	open, close string
+}{
+	{regex.InlineMath, "$", "$"},
+	{regex.InlineMathParen, `\(`, `\)`},
+}
+
+// text prepares a piece of a line for rendering. Macro definitions are
+// taken out of its inline math, dropping expressions that only define
+// macros, and a line left blank by that is dropped too so definitions print
+// nothing. References to equations are resolved in math and text. It
+// returns the text and the macros its math must be typeset after.
+func (d *document) text(line string) (string, string) {
+	defined := false
+	for _, delims := range inlineDelimiters {
+		line = delims.pattern.ReplaceAllStringFunc(line, func(match string) string {
+			content, ok := d.macros.Extract(match[len(delims.open) : len(match)-len(delims.close)])
+			if ok {
+				defined = true
+				if content == "" {
+					return ""
+				}
+			}
+			return delims.open + d.equations.ResolveMath(content) + delims.close
+		})
+	}
+	if defined && strings.TrimSpace(line) == "" {
+		return "", d.macros.String()
+	}
+	return d.equations.ResolveText(line), d.macros.String()
+}
+
+// displayMath prepares a display math block for rendering. It returns the
+// LaTeX to render, the macros to typeset before it and its equation tag,
+// e.g. "(2)" or "" if it is unnumbered. show is false if the block only
+// defines macros and should print nothing.
+func (d *document) displayMath(content string) (mathContent, macros, tag string, show bool) {
+	content, defined := d.macros.Extract(content)
+	if defined && content == "" {
+		return "", "", "", false
+	}
+	content, tag = d.equations.Number(content)
+	return d.equations.ResolveMath(content), d.macros.String(), tag, true
+}
+
+// mathEnvEnd finds the end of a bare math environment in s, which starts
+// depth environments deep. It returns the index just past the \end that
+// closes the last of them, or -1 and the depth reached at the end of s if
+// they are still open.
+func mathEnvEnd(s string, depth int) (int, int) {
+	for _, m := range regex.EnvDelimiter.FindAllStringSubmatchIndex(s, -1) {
+		if s[m[2]:m[3]] == "begin" {
+			depth++
+			continue
+		}
+		depth--
+		if depth == 0 {
+			return m[1], 0
+		}
+	}
+	return -1, depth
+}
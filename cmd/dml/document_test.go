@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDocumentText(t *testing.T) {
+	var doc document
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"Let $\\newcommand{\\vv}{\\mathbf{v}}$ be given.\n", "Let  be given.\n"},
+		{"$\\DeclareMathOperator{\\sgn}{sgn}$ \\(\\def\\R{\\mathbb{R}}\\)\n", ""},
+		{"Then $\\def\\w{w} \\vv + \\w$ and $x$.\n", "Then $\\vv + \\w$ and $x$.\n"},
+		{"No math here.\n", "No math here.\n"},
+	}
+	for _, test := range tests {
+		if got, _ := doc.text(test.line); got != test.want {
+			t.Errorf("text(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+
+	want := `\providecommand{\vv}{}\renewcommand{\vv}{\mathbf{v}}\DeclareRobustCommand{\sgn}{\operatorname{sgn}}\def\R{\mathbb{R}}\def\w{w}`
+	if _, got := doc.text("x"); got != want {
+		t.Errorf("Recorded macros = %q, want %q", got, want)
+	}
+}
+
+func TestDocumentUnsafeMacro(t *testing.T) {
+	doc := newDocument(false)
+	line := "$\\def\\leak{\\input{/etc/passwd}}$ then $\\def\\w{w}$\n"
+	if got, defs := doc.text(line); got != "$\\def\\leak{\\input{/etc/passwd}}$ then \n" || defs != `\def\w{w}` {
+		t.Errorf("text(%q) = %q, %q", line, got, defs)
+	}
+
+	doc = newDocument(true)
+	if _, defs := doc.text(line); defs != `\def\leak{\input{/etc/passwd}}\def\w{w}` {
+		t.Errorf("Unsafe text(%q) recorded %q", line, defs)
+	}
+}
+
+func TestDocumentEquations(t *testing.T) {
+	var doc document
+
+	if _, _, _, show := doc.displayMath(`\newcommand{\e}{\mathrm{e}}`); show {
+		t.Errorf("Expected a definition-only block to print nothing")
+	}
+
+	displays := []struct {
+		content string
+		latex   string
+		tag     string
+	}{
+		{`x = y`, `x = y`, ``},
+		{`\e^{i\pi} = -1 \label{eq:euler}`, `\e^{i\pi} = -1`, `(1)`},
+		{`\begin{equation} a = b \end{equation}`, `\begin{equation*} a = b \end{equation*}`, `(2)`},
+		{`c = d \tag{*}`, `c = d`, `(*)`},
+		{`\begin{align} p &= q \notag \end{align}`, `\begin{align*} p &= q  \end{align*}`, ``},
+		{`f = g \quad \text{by } \eqref{eq:euler}`, `f = g \quad \text{by } \textup{(1)}`, ``},
+	}
+	for _, d := range displays {
+		latex, macros, tag, show := doc.displayMath(d.content)
+		if !show || latex != d.latex || tag != d.tag {
+			t.Errorf("displayMath(%q) = %q, %q, %v, want %q, %q", d.content, latex, tag, show, d.latex, d.tag)
+		}
+		if macros != `\providecommand{\e}{}\renewcommand{\e}{\mathrm{e}}` {
+			t.Errorf("displayMath(%q) macros = %q", d.content, macros)
+		}
+	}
+
+	got, _ := doc.text(`See \eqref{eq:euler}, $x \ref{eq:euler}$ and \ref{eq:later}.`)
+	if want := `See (1), $x \textup{1}$ and ??.`; got != want {
+		t.Errorf("text with references = %q, want %q", got, want)
+	}
+}
+
+func TestMathEnvEnd(t *testing.T) {
+	tests := []struct {
+		s         string
+		depth     int
+		end       int
+		wantDepth int
+	}{
+		{`\begin{align} x \end{align} text`, 0, 27, 0},
+		{`\begin{equation}\begin{split} a \end{split}\end{equation}`, 0, 57, 0},
+		{`\begin{gather}` + "\n", 0, -1, 1},
+		{`a \\ \begin{cases} b \end{cases}`, 1, -1, 1},
+		{`c \end{gather} d`, 1, 14, 0},
+	}
+	for _, test := range tests {
+		end, depth := mathEnvEnd(test.s, test.depth)
+		if end != test.end || depth != test.wantDepth {
+			t.Errorf("mathEnvEnd(%q, %d) = %d, %d, want %d, %d", test.s, test.depth, end, depth, test.end, test.wantDepth)
+		}
+	}
+}
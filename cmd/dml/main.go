@@ -3,17 +3,31 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"dml/internal/cache"
+	"dml/internal/config"
 	"dml/internal/latex"
 	"dml/internal/markdown"
 	"dml/internal/regex"
 	"dml/internal/terminal"
+	"dml/internal/unicode"
 
 	"github.com/gomarkdown/markdown/parser"
 )
@@ -24,14 +38,33 @@ func main() {
 	cFlag := flag.String("c", "", "Short alias for --colour. Overrides --colour if set.")
 	sizeFlag := flag.Int("size", 0, "Target terminal rows for LaTeX images (0 for default: 1 for inline, auto for display).")
 	sFlag := flag.Int("s", 0, "Short alias for --size.")
-	dpiFlag := flag.Int("dpi", 300, "Set DPI for rendering LaTeX images.")
+	dpiFlag := flag.Int("dpi", 0, "Set DPI for rendering LaTeX images (0 for adaptive DPI based on terminal cell height).")
 	dFlag := flag.Int("d", 0, "Short alias for --dpi. Overrides --dpi if set (and not 0).")
 	renderAllLatexFlag := flag.Bool("render-all-latex", false, "Render entire input as a single LaTeX document/image.")
 	lFlag := flag.Bool("l", false, "Short alias for --render-all-latex.")
 	debugFlag := flag.Bool("debug", false, "Enable verbose debug output.")
 	dDebugFlag := flag.Bool("D", false, "Short alias for --debug.")
-	fuzzFlag := flag.String("fuzz-level", "", "Set ImageMagick -fuzz level for transparency (e.g., \"5%\", \"10%\", \"30%\"). Defaults to \"30%\" if not set.")
-	fShortFlag := flag.String("f", "", "Short alias for --fuzz-level. Overrides --fuzz-level if set.")
+	fuzzFlag := flag.String("fuzz-level", "", "Deprecated and ignored: transparency is now computed exactly.")
+	fShortFlag := flag.String("f", "", "Deprecated alias for --fuzz-level; ignored.")
+	batchFlag := flag.Bool("batch", false, "Read all input first and typeset every math expression in one LaTeX run (automatic when input is a file).")
+	noUnicodeFlag := flag.Bool("no-unicode", false, "Disable the Unicode fast path; render all math through LaTeX.")
+	cacheStatsFlag := flag.Bool("cache-stats", false, "Print render cache statistics (hits, misses, entries, size) and exit.")
+	cacheClearFlag := flag.Bool("cache-clear", false, "Remove all entries from the render cache and exit.")
+	cacheMaxMBFlag := flag.Int("cache-max-mb", 100, "Maximum render cache size in MB; least-recently-used entries are evicted beyond this.")
+	cachePruneFlag := flag.String("cache-prune", "", "Remove cache entries not used within AGE (e.g. \"72h\", \"30d\") and exit.")
+	rasteriserFlag := flag.String("rasteriser", "auto", "How math is rasterised: auto, pdf (PDF via pdftoppm or mutool) or dvipng (DVI via dvipng, pdflatex only).")
+	renderTimeoutFlag := flag.Duration("render-timeout", 30*time.Second, "Kill any LaTeX or rasteriser command running longer than this (e.g. \"10s\"; 0 for no limit) and print the math as text.")
+	keepTempFlag := flag.Bool("keep-temp", false, "Keep the temporary directory of every LaTeX run for inspection instead of removing it.")
+	unsafeFlag := flag.Bool("unsafe", false, "Trust the input: allow file access and other dangerous TeX commands and lift the expression length limit.")
+	noFormatFlag := flag.Bool("no-format", false, "Load the LaTeX preamble on every run instead of using a precompiled format.")
+	jobsFlag := flag.Int("jobs", runtime.NumCPU(), "Maximum number of math renders to run in parallel.")
+	jFlag := flag.Int("j", 0, "Short alias for --jobs. Overrides --jobs if set (and not 0).")
+	preambleFlag := flag.String("preamble", "", "Add the LaTeX in FILE to the preamble of every document, e.g. for \\newcommand macros. Overrides the config file's preamble.")
+	var packageFlags stringList
+	flag.Var(&packageFlags, "package", "Load a LaTeX package, given as NAME or NAME[options] (e.g. siunitx[per-mode=symbol]). May be repeated.")
+	engineFlag := flag.String("engine", "auto", "TeX engine: auto, "+strings.Join(latex.EngineNames(), ", ")+". auto uses the first one found on PATH.")
+	protocolFlag := flag.String("protocol", "auto", "Terminal graphics protocol for math images: auto, "+strings.Join(terminal.ProtocolNames(), ", ")+". auto asks the terminal, and uses text when output isn't a terminal.")
+	kittyPlaceholdersFlag := flag.Bool("kitty-placeholders", false, "Draw Kitty images as Unicode placeholder cells, which tmux and scrollback keep in place.")
 
 	flag.Parse() // Parse all flags first
 
@@ -68,22 +101,10 @@ func main() {
 	if dFlagSet { // If -d was explicitly provided on the command line, it takes precedence
 		effectiveDPI = *dFlag
 	}
-	if effectiveDPI <= 0 { // Ensure DPI is positive, default to 300 if invalid
-		effectiveDPI = 300
-	}
 
 	isRenderAllLatexMode := *renderAllLatexFlag || *lFlag
 	isDebugMode := *debugFlag || *dDebugFlag
 
-	// Determine the effective fuzz level string
-	effectiveFuzz := ""
-	if *fShortFlag != "" {
-		effectiveFuzz = *fShortFlag
-	} else if *fuzzFlag != "" {
-		effectiveFuzz = *fuzzFlag
-	}
-	// Note: If effectiveFuzz remains empty, RenderMath/RenderFullDocument will use a default.
-
 	// Set debug mode in packages
 	if isDebugMode {
 		latex.SetDebug(true)
@@ -92,23 +113,307 @@ func main() {
 		fmt.Fprintln(os.Stderr, "DEBUG: dml starting")
 		fmt.Fprintln(os.Stderr, "DEBUG: Flags parsed.")
 		fmt.Fprintf(os.Stderr, "DEBUG: isRenderAllLatexMode: %v\n", isRenderAllLatexMode)
+		if *fuzzFlag != "" || *fShortFlag != "" {
+			fmt.Fprintln(os.Stderr, "DEBUG: --fuzz-level is deprecated and has no effect")
+		}
 	}
 
+	// Open the render cache; failure just means rendering without one
+	renderCache, cacheErr := openCache(*cacheMaxMBFlag)
+	if cacheErr != nil && isDebugMode {
+		fmt.Fprintf(os.Stderr, "DEBUG: Render cache disabled: %v\n", cacheErr)
+	}
+
+	// Cache management commands run instead of rendering
+	if *cacheStatsFlag || *cacheClearFlag || *cachePruneFlag != "" {
+		if cacheErr != nil {
+			fmt.Fprintf(os.Stderr, "Error opening render cache: %v\n", cacheErr)
+			os.Exit(1)
+		}
+		os.Exit(runCacheCommands(renderCache, *cacheClearFlag, *cachePruneFlag, *cacheStatsFlag))
+	}
+
+	if renderCache != nil && isDebugMode {
+		fmt.Fprintf(os.Stderr, "DEBUG: Using render cache at %s\n", renderCache.Dir())
+	}
+
+	// Only now that output will be drawn is the terminal asked about graphics
+	protocol, err := terminal.ParseProtocol(*protocolFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	terminal.SetProtocol(protocol)
+	terminal.SetKittyPlaceholders(*kittyPlaceholdersFlag)
+	if isDebugMode {
+		fmt.Fprintf(os.Stderr, "DEBUG: Using graphics protocol: %s, Kitty placeholders: %v\n", protocol, *kittyPlaceholdersFlag)
+	}
+	if protocol != terminal.ProtocolText {
+		if hint := terminal.TmuxPassthroughHint(); hint != "" {
+			fmt.Fprintf(os.Stderr, "Hint: %s\n", hint)
+		}
+	}
+
+	// Whatever else the terminal is asked is asked here too, before renders
+	// run in parallel and their replies could be mixed up
+	if protocol != terminal.ProtocolText || effectiveDPI <= 0 {
+		terminal.SetCellSize(terminal.QueryCellSize())
+	}
+	if protocol == terminal.ProtocolSixel {
+		if bg, err := terminal.QueryBackground(); err == nil {
+			terminal.SetBackground(bg)
+		} else if isDebugMode {
+			fmt.Fprintf(os.Stderr, "DEBUG: Background colour unknown (%v); assuming black\n", err)
+		}
+	}
+
+	// Select the TeX engine. When auto-detection finds nothing we keep the
+	// default so text-only input still works and math reports the failure.
+	engine, engineErr := latex.LookupEngine(*engineFlag)
+	if engineErr != nil {
+		if !strings.EqualFold(*engineFlag, "auto") {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", engineErr)
+			os.Exit(1)
+		}
+		if isDebugMode {
+			fmt.Fprintf(os.Stderr, "DEBUG: %v\n", engineErr)
+		}
+	}
+	preamble, err := userPreamble(*preambleFlag, packageFlags, isDebugMode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts := latex.Options{Engine: engine, Rasteriser: *rasteriserFlag, Preamble: preamble, Timeout: *renderTimeoutFlag, Unsafe: *unsafeFlag, KeepTemp: *keepTempFlag}
+	if renderCache != nil {
+		opts.Cache = renderCache
+		if !*noFormatFlag {
+			opts.FormatDir = formatDir(renderCache)
+		}
+	}
+	renderer, err := latex.NewRenderer(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if isDebugMode {
+		fmt.Fprintf(os.Stderr, "DEBUG: Using TeX engine: %s, rasteriser: %s, safe mode: %v\n", renderer.Engine().Name(), renderer.Rasteriser(), !*unsafeFlag)
+	}
+
+	jobs := *jobsFlag
+	if *jFlag != 0 {
+		jobs = *jFlag
+	}
+	if jobs < 1 { // At least one render must be able to run
+		jobs = 1
+	}
+
+	// Clear out temp dirs abandoned by earlier runs
+	latex.SweepTempDirs(latex.StaleTempAge)
+
+	// A DPI of 0 (or anything invalid) selects adaptive DPI from the terminal cell height
+	if effectiveDPI <= 0 {
+		effectiveDPI = adaptiveDPI(isDebugMode)
+	}
+
+	// Interrupting dml must also stop running TeX commands, which are in
+	// process groups of their own and don't receive the terminal's signals
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	if isRenderAllLatexMode {
-		processFullDocument(effectivecolour, effectiveSize, effectiveDPI, effectiveFuzz, isDebugMode)
+		processFullDocument(ctx, renderer, effectivecolour, effectiveSize, effectiveDPI, isDebugMode)
 	} else {
-		processStreamingDocument(effectivecolour, effectiveSize, effectiveDPI, effectiveFuzz, isDebugMode)
+		var input io.Reader = os.Stdin
+		if (*batchFlag || stdinIsFile()) && protocol != terminal.ProtocolText {
+			inputBytes, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading standard input: %v\n", err)
+				os.Exit(1)
+			}
+			prerenderMath(ctx, renderer, string(inputBytes), effectivecolour, effectiveDPI, jobs, !*noUnicodeFlag, isDebugMode)
+			input = bytes.NewReader(inputBytes)
+		}
+		processStreamingDocument(ctx, renderer, input, os.Stdout, effectivecolour, effectiveSize, effectiveDPI, jobs, !*noUnicodeFlag, isDebugMode)
+	}
+
+	// Record this run's hit/miss counts for --cache-stats
+	if renderCache != nil {
+		if err := renderCache.Flush(); err != nil && isDebugMode {
+			fmt.Fprintf(os.Stderr, "DEBUG: Failed to save cache statistics: %v\n", err)
+		}
 	}
 
 	// Final debug messages if debug mode is enabled
 	if isDebugMode {
-		fmt.Fprintf(os.Stderr, "DEBUG: dml execution completed. If math rendering issues occurred, check for LaTeX or convert errors.")
+		fmt.Fprintf(os.Stderr, "DEBUG: dml execution completed. If math rendering issues occurred, check for LaTeX or rasteriser errors.")
 		fmt.Fprintln(os.Stderr, "DEBUG: dml exiting.")
 	}
+
+	if ctx.Err() != nil {
+		os.Exit(130) // Interrupted
+	}
+}
+
+// stringList is a flag that may be repeated, collecting every value
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// userPreamble builds the LaTeX added to every document's preamble: the
+// config file's packages, then those given with --package, then the
+// preamble file. preambleFile replaces the config file's preamble if set.
+func userPreamble(preambleFile string, packages []string, isDebugMode bool) (string, error) {
+	// Without a config location, e.g. with no $HOME under cron, there is no
+	// config file to read, as with a missing render cache
+	cfg := &config.Config{}
+	path, err := config.DefaultPath()
+	if err != nil {
+		if isDebugMode {
+			fmt.Fprintf(os.Stderr, "DEBUG: No config file: %v\n", err)
+		}
+	} else if cfg, err = config.Load(path); err != nil {
+		return "", fmt.Errorf("reading config: %v", err)
+	}
+	if isDebugMode && (cfg.Preamble != "" || len(cfg.Packages) > 0) {
+		fmt.Fprintf(os.Stderr, "DEBUG: Loaded config from %s\n", path)
+	}
+
+	var lines []string
+	for _, spec := range append(cfg.Packages, packages...) {
+		line, err := latex.UsePackage(spec)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+
+	if preambleFile == "" {
+		preambleFile = cfg.Preamble
+	}
+	if preambleFile != "" {
+		data, err := ioutil.ReadFile(preambleFile)
+		if err != nil {
+			return "", fmt.Errorf("reading preamble: %v", err)
+		}
+		lines = append(lines, strings.TrimRight(string(data), "\n"))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// adaptiveDPI picks a DPI matching the terminal's cell height, falling back
+// to terminal.FallbackDPI if the terminal doesn't report its geometry
+func adaptiveDPI(isDebugMode bool) int {
+	cell, err := terminal.CachedCellSize()
+	if err != nil {
+		if isDebugMode {
+			fmt.Fprintf(os.Stderr, "DEBUG: Adaptive DPI unavailable (%v); using %d DPI\n", err, terminal.FallbackDPI)
+		}
+		return terminal.FallbackDPI
+	}
+	dpi := terminal.AdaptiveDPI(cell)
+	if isDebugMode {
+		fmt.Fprintf(os.Stderr, "DEBUG: Adaptive DPI %d for %dx%d px cells\n", dpi, cell.Width, cell.Height)
+	}
+	return dpi
+}
+
+// openCache opens the render cache in its default location with a limit of maxMB megabytes
+func openCache(maxMB int) (*cache.Cache, error) {
+	dir, err := cache.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	return cache.Open(dir, int64(maxMB)*1024*1024)
+}
+
+// formatDir is where precompiled LaTeX formats are kept, alongside the render cache
+func formatDir(c *cache.Cache) string {
+	return filepath.Join(c.Dir(), "formats")
+}
+
+// runCacheCommands performs the requested cache maintenance in a fixed order
+// (clear, prune, stats) and returns the process exit code
+func runCacheCommands(c *cache.Cache, clear bool, pruneAge string, stats bool) int {
+	if clear {
+		if err := c.Clear(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error clearing render cache: %v\n", err)
+			return 1
+		}
+		if err := os.RemoveAll(formatDir(c)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing precompiled formats: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Cleared render cache at %s\n", c.Dir())
+	}
+
+	if pruneAge != "" {
+		age, err := parseAge(pruneAge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --cache-prune age '%s': %v\n", pruneAge, err)
+			return 2
+		}
+		removed, freed := c.Prune(age)
+		fmt.Printf("Pruned %d entries (%s) unused for more than %s\n", removed, formatBytes(freed), pruneAge)
+	}
+
+	if stats {
+		st := c.Stats()
+		hitRate := 0.0
+		if st.Hits+st.Misses > 0 {
+			hitRate = 100 * float64(st.Hits) / float64(st.Hits+st.Misses)
+		}
+		fmt.Printf("Cache directory: %s\n", st.Dir)
+		fmt.Printf("Entries:         %d\n", st.Entries)
+		fmt.Printf("Disk usage:      %s / %s\n", formatBytes(st.Bytes), formatBytes(st.MaxBytes))
+		fmt.Printf("Hits:            %d\n", st.Hits)
+		fmt.Printf("Misses:          %d\n", st.Misses)
+		fmt.Printf("Hit rate:        %.1f%%\n", hitRate)
+	}
+
+	return 0
+}
+
+// parseAge parses a Go duration, additionally accepting a whole number of days ("30d")
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("expected a number of days such as \"30d\"")
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	age, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if age < 0 {
+		return 0, fmt.Errorf("age must not be negative")
+	}
+	return age, nil
+}
+
+// formatBytes renders a byte count in human-readable units
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
 }
 
 // processFullDocument handles the full document rendering mode
-func processFullDocument(effectivecolour string, effectiveSize, effectiveDPI int, effectiveFuzz string, isDebugMode bool) {
+func processFullDocument(ctx context.Context, renderer *latex.Renderer, effectivecolour string, effectiveSize, effectiveDPI int, isDebugMode bool) {
 	if isDebugMode {
 		fmt.Fprintln(os.Stderr, "DEBUG: Reading standard input (full document) for render-all-latex mode...")
 	}
@@ -125,10 +430,44 @@ func processFullDocument(effectivecolour string, effectiveSize, effectiveDPI int
 		fmt.Fprintf(os.Stderr, "DEBUG: Finished reading input (%d bytes).\n", len(inputBytes))
 	}
 
-	// Preprocess \[...\] and \(...\) to $$...$$ and $...$ for correct math parsing
-	preprocessed := regex.DisplayMathBracket.ReplaceAllStringFunc(inputString, func(match string) string {
+	// The whole document is one image, so without graphics there is nothing to render
+	if terminal.CurrentProtocol() == terminal.ProtocolText {
+		if isDebugMode {
+			fmt.Fprintln(os.Stderr, "DEBUG: No graphics protocol; printing the input as it is")
+		}
+		fmt.Print(inputString)
+		return
+	}
+
+	latexBody := fullDocumentLaTeX(inputString)
+	img, renderErr := renderer.RenderFullDocument(ctx, latexBody, effectivecolour, effectiveDPI)
+	if renderErr != nil {
+		fmt.Fprintf(os.Stderr, "Error in full LaTeX rendering mode: %v\n", renderErr)
+		debugCompileLog(renderErr, isDebugMode)
+		// In full render mode, if LaTeX fails, print the original input so user can debug
+		fmt.Print(inputString)
+		os.Exit(1)
+	}
+
+	imageStr, imageErr := terminal.Inline(img, true, effectiveSize)
+	if imageErr != nil {
+		fmt.Fprintf(os.Stderr, "Error generating terminal image for full document: %v\n", imageErr)
+		// If image generation fails, print original input
+		fmt.Print(inputString)
+		os.Exit(1)
+	}
+	fmt.Print(imageStr)
+}
+
+// fullDocumentLaTeX converts a Markdown document to the LaTeX body that
+// --render-all-latex typesets
+func fullDocumentLaTeX(input string) string {
+	// Preprocess \[...\] and \(...\) to $$...$$ and $...$ for correct math
+	// parsing. $$...$$ is only a math block at the start of a paragraph, so
+	// display math is set apart from the text around it.
+	preprocessed := regex.DisplayMathBracket.ReplaceAllStringFunc(input, func(match string) string {
 		content := strings.TrimSpace(match[2 : len(match)-2])
-		return "$" + content + "$"
+		return "\n\n$$" + content + "$$\n\n"
 	})
 	preprocessed = regex.InlineMathParen.ReplaceAllStringFunc(preprocessed, func(match string) string {
 		content := strings.TrimSpace(match[2 : len(match)-2])
@@ -141,37 +480,56 @@ func processFullDocument(effectivecolour string, effectiveSize, effectiveDPI int
 
 	var latexBodyBuilder strings.Builder
 	markdown.GenerateLatexFromAST(docNode, &latexBodyBuilder)
-	latexBody := latexBodyBuilder.String()
-
-	img, renderErr := latex.RenderFullDocument(latexBody, effectivecolour, effectiveDPI, effectiveFuzz)
-	if renderErr != nil {
-		fmt.Fprintf(os.Stderr, "Error in full LaTeX rendering mode: %v\n", renderErr)
-		// In full render mode, if LaTeX fails, print the original input so user can debug
-		fmt.Print(inputString)
-		os.Exit(1)
-	}
-
-	kittyStr, kittyErr := terminal.KittyInline(img, true, effectiveSize)
-	if kittyErr != nil {
-		fmt.Fprintf(os.Stderr, "Error generating Kitty protocol for full document: %v\n", kittyErr)
-		// If Kitty protocol generation fails, print original input
-		fmt.Print(inputString)
-		os.Exit(1)
-	}
-	fmt.Print(kittyStr)
+	return latexBodyBuilder.String()
 }
 
-// processStreamingDocument handles the streaming mode with line-by-line processing
-func processStreamingDocument(effectivecolour string, effectiveSize, effectiveDPI int, effectiveFuzz string, isDebugMode bool) {
+// processStreamingDocument handles the streaming mode with line-by-line processing.
+// Lines and display math blocks are rendered on up to jobs goroutines and
+// written in input order as soon as they and everything before them are done.
+func processStreamingDocument(ctx context.Context, renderer *latex.Renderer, input io.Reader, out io.Writer, effectivecolour string, effectiveSize, effectiveDPI, jobs int, useUnicode, isDebugMode bool) {
 	if isDebugMode {
-		fmt.Fprintln(os.Stderr, "DEBUG: Entering standard processing mode (line-by-line streaming with state).")
+		fmt.Fprintf(os.Stderr, "DEBUG: Entering standard processing mode (line-by-line streaming with state, %d jobs).\n", jobs)
 	}
 
-	reader := bufio.NewReader(os.Stdin)
-	writer := bufio.NewWriter(os.Stdout) // Use a buffered writer for output flushing
+	reader := bufio.NewReader(input)
+	output := newOutputQueue(out, jobs) // Renders in parallel, writes in order
 
 	var mathBuffer strings.Builder // Buffer for collecting multi-line math content
 	inDisplayMath := false         // State flag
+	mathOpen := ""                 // The delimiter that opened the display math block, $$ or \[
+	mathEnvDepth := 0              // Nesting depth inside a bare \begin{align} etc., 0 outside
+
+	// Macros and equation numbers, updated as the input is read so each
+	// render sees exactly what came before it however renders are scheduled
+	doc := newDocument(renderer.Unsafe())
+
+	// submitText queues a piece of a line for inline math and Markdown processing
+	submitText := func(text string) {
+		text, defs := doc.text(text)
+		output.Submit(func() string {
+			processed := processInlineMath(ctx, renderer, text, defs, effectivecolour, effectiveSize, effectiveDPI, useUnicode, isDebugMode)
+			return markdown.ApplyFormatting(processed)
+		})
+	}
+
+	// submitDisplayMath queues a display math block for rendering, given
+	// with the delimiters it was written with (none for a bare environment)
+	// so that it can be printed as written if it isn't rendered. A block
+	// that only defines macros prints nothing, and false is returned.
+	submitDisplayMath := func(open, mathContent, close string) bool {
+		source := strings.TrimSpace(open) + mathContent + strings.TrimSpace(close)
+		mathContent, defs, tag, show := doc.displayMath(mathContent)
+		if !show {
+			if isDebugMode {
+				fmt.Fprintln(os.Stderr, "DEBUG: Display math only defines macros; nothing to render")
+			}
+			return false
+		}
+		output.Submit(func() string {
+			return renderDisplayMath(ctx, renderer, source, mathContent, defs, tag, effectivecolour, effectiveSize, effectiveDPI, isDebugMode)
+		})
+		return true
+	}
 
 	if isDebugMode {
 		fmt.Fprintln(os.Stderr, "DEBUG: Starting line-by-line input reading and processing loop...")
@@ -182,14 +540,45 @@ func processStreamingDocument(effectivecolour string, effectiveSize, effectiveDP
 
 		if err != nil && err != io.EOF {
 			fmt.Fprintf(os.Stderr, "Error reading input line: %v\n", err)
-			writer.Flush() // Flush any pending output
+			output.Close() // Write any pending output
 			os.Exit(1)
 		}
 
 		// Determine if this is the last line
 		isLastLine := (err == io.EOF)
 
-		if inDisplayMath {
+		if mathEnvDepth > 0 {
+			// We are inside a bare math environment, which is buffered whole
+			if isDebugMode {
+				fmt.Fprintf(os.Stderr, "DEBUG: In math environment (depth %d), processing line: %s\n", mathEnvDepth, strings.TrimSpace(inputLine))
+			}
+
+			end, depth := mathEnvEnd(inputLine, mathEnvDepth)
+			if end >= 0 {
+				if isDebugMode {
+					fmt.Fprintln(os.Stderr, "DEBUG: Found end of math environment.")
+				}
+				mathBuffer.WriteString(inputLine[:end]) // The \end is part of the block
+				mathContent := mathBuffer.String()
+				mathBuffer.Reset()
+				mathEnvDepth = 0
+
+				shown := submitDisplayMath("", mathContent, "")
+
+				// Process the rest of the line after the environment
+				remainingLine := inputLine[end:]
+				if len(remainingLine) > 0 && (shown || strings.TrimSpace(remainingLine) != "") {
+					if isDebugMode {
+						fmt.Fprintf(os.Stderr, "DEBUG: Processing remaining line after math environment: %s\n", strings.TrimSpace(remainingLine))
+					}
+					submitText(remainingLine)
+				}
+			} else {
+				mathBuffer.WriteString(inputLine)
+				mathEnvDepth = depth
+			}
+
+		} else if inDisplayMath {
 			// We are inside a display math block
 			if isDebugMode {
 				fmt.Fprintf(os.Stderr, "DEBUG: In display math mode, processing line: %s\n", strings.TrimSpace(inputLine))
@@ -210,44 +599,18 @@ func processStreamingDocument(effectivecolour string, effectiveSize, effectiveDP
 
 				// Render the collected math content
 				if isDebugMode {
-					fmt.Fprintf(os.Stderr, "DEBUG: Attempting to render display math (length: %d chars)\n", len(mathContent))
-				}
-				img, renderErr := latex.RenderMath(mathContent, effectivecolour, true, effectiveDPI, effectiveFuzz)
-				if renderErr != nil {
-					fmt.Fprintf(os.Stderr, "ERROR: Rendering display math failed: %v\n", renderErr)
-					// On error, print the un-rendered content as text
-					writer.WriteString("$$")
-					writer.WriteString(mathContent)
-					writer.WriteString("$$\n") // Add newline if it's missing from content
-				} else {
-					if isDebugMode {
-						fmt.Fprintln(os.Stderr, "DEBUG: Math rendering successful, generating Kitty protocol")
-					}
-					kittyStr, kittyErr := terminal.KittyInline(img, true, effectiveSize)
-					if kittyErr != nil {
-						fmt.Fprintf(os.Stderr, "ERROR: Generating Kitty protocol failed: %v\n", kittyErr)
-						// On error, print the un-rendered content as text
-						writer.WriteString("$$")
-						writer.WriteString(mathContent)
-						writer.WriteString("$$\n") // Add newline if it's missing from content
-					} else {
-						if isDebugMode {
-							fmt.Fprintln(os.Stderr, "DEBUG: Successfully generated Kitty protocol for display math")
-						}
-						writer.WriteString(kittyStr) // Write the rendered image protocol
-					}
+					fmt.Fprintf(os.Stderr, "DEBUG: Queueing display math for rendering (length: %d chars)\n", len(mathContent))
 				}
+				shown := submitDisplayMath(mathOpen, mathContent, inputLine[endMatchIdx[0]:endMatchIdx[1]])
 
 				// Process the rest of the line after the closing delimiter
 				remainingLine := inputLine[endMatchIdx[1]:]
-				if len(remainingLine) > 0 {
+				if len(remainingLine) > 0 && (shown || strings.TrimSpace(remainingLine) != "") {
 					// Process remaining part of the line as normal text
 					if isDebugMode {
 						fmt.Fprintf(os.Stderr, "DEBUG: Processing remaining line after display math: %s\n", strings.TrimSpace(remainingLine))
 					}
-					processedRemaining := processInlineMath(remainingLine, effectivecolour, effectiveSize, effectiveDPI, effectiveFuzz, isDebugMode)
-					finalRemainingOutput := markdown.ApplyFormatting(processedRemaining)
-					writer.WriteString(finalRemainingOutput)
+					submitText(remainingLine)
 				}
 
 			} else {
@@ -266,8 +629,42 @@ func processStreamingDocument(effectivecolour string, effectiveSize, effectiveDP
 
 			startMatchIdx := regex.StartDisplayMath.FindStringIndex(inputLine)
 			endMatchIdx := regex.EndDisplayMath.FindStringIndex(inputLine) // Check for same-line closing
+			envMatchIdx := regex.BeginMathEnv.FindStringIndex(inputLine)   // \begin{align} etc. with no $$
+
+			if envMatchIdx != nil && (startMatchIdx == nil || envMatchIdx[0] < startMatchIdx[0]) {
+				// Found a bare math environment, rendered as written from
+				// its \begin to the matching \end
+				if isDebugMode {
+					fmt.Fprintln(os.Stderr, "DEBUG: Found start of math environment.")
+				}
+				beforeEnv := inputLine[:envMatchIdx[0]]
+				if len(beforeEnv) > 0 {
+					if isDebugMode {
+						fmt.Fprintf(os.Stderr, "DEBUG: Processing text before math environment: %s\n", strings.TrimSpace(beforeEnv))
+					}
+					submitText(beforeEnv)
+				}
+
+				env := inputLine[envMatchIdx[0]:]
+				if end, depth := mathEnvEnd(env, 0); end >= 0 {
+					// The whole environment is on this line
+					shown := submitDisplayMath("", env[:end], "")
+					afterEnv := env[end:]
+					if len(afterEnv) > 0 && (shown || strings.TrimSpace(afterEnv) != "") {
+						if isDebugMode {
+							fmt.Fprintf(os.Stderr, "DEBUG: Processing text after math environment: %s\n", strings.TrimSpace(afterEnv))
+						}
+						submitText(afterEnv)
+					}
+				} else {
+					mathBuffer.WriteString(env)
+					mathEnvDepth = depth
+					if isDebugMode {
+						fmt.Fprintln(os.Stderr, "DEBUG: Started buffering math environment.")
+					}
+				}
 
-			if startMatchIdx != nil && (endMatchIdx == nil || endMatchIdx[0] < startMatchIdx[0]) {
+			} else if startMatchIdx != nil && (endMatchIdx == nil || endMatchIdx[0] < startMatchIdx[0]) {
 				// Found starting delimiter for a multi-line block (and no closing before it)
 				if isDebugMode {
 					fmt.Fprintln(os.Stderr, "DEBUG: Found display math start delimiter. Switching to math state.")
@@ -278,13 +675,12 @@ func processStreamingDocument(effectivecolour string, effectiveSize, effectiveDP
 					if isDebugMode {
 						fmt.Fprintf(os.Stderr, "DEBUG: Processing text before delimiter: %s\n", strings.TrimSpace(beforeDelimiter))
 					}
-					processedBefore := processInlineMath(beforeDelimiter, effectivecolour, effectiveSize, effectiveDPI, effectiveFuzz, isDebugMode)
-					finalBeforeOutput := markdown.ApplyFormatting(processedBefore)
-					writer.WriteString(finalBeforeOutput)
+					submitText(beforeDelimiter)
 				}
 
 				// Start buffering from the content *after* the delimiter on this line
 				mathBuffer.WriteString(inputLine[startMatchIdx[1]:])
+				mathOpen = inputLine[startMatchIdx[0]:startMatchIdx[1]]
 				inDisplayMath = true // Enter display math state
 				if isDebugMode {
 					fmt.Fprintln(os.Stderr, "DEBUG: Started buffering math content.")
@@ -301,77 +697,61 @@ func processStreamingDocument(effectivecolour string, effectiveSize, effectiveDP
 					if isDebugMode {
 						fmt.Fprintf(os.Stderr, "DEBUG: Processing text before single-line math: %s\n", strings.TrimSpace(beforeDelimiter))
 					}
-					processedBefore := processInlineMath(beforeDelimiter, effectivecolour, effectiveSize, effectiveDPI, effectiveFuzz, isDebugMode)
-					finalBeforeOutput := markdown.ApplyFormatting(processedBefore)
-					writer.WriteString(finalBeforeOutput)
+					submitText(beforeDelimiter)
 				}
 
 				// Extract and process the math content
 				mathContent := inputLine[startMatchIdx[1]:endMatchIdx[0]]
 				if isDebugMode {
-					fmt.Fprintf(os.Stderr, "DEBUG: Rendering single-line display math: %s\n", strings.TrimSpace(mathContent))
-				}
-				img, renderErr := latex.RenderMath(mathContent, effectivecolour, true, effectiveDPI, effectiveFuzz)
-				if renderErr != nil {
-					fmt.Fprintf(os.Stderr, "ERROR: Rendering display math failed: %v\n", renderErr)
-					// On error, print the un-rendered content as text
-					writer.WriteString("$$")
-					writer.WriteString(mathContent)
-					writer.WriteString("$$\n") // Add newline if it's missing from content
-				} else {
-					kittyStr, kittyErr := terminal.KittyInline(img, true, effectiveSize)
-					if kittyErr != nil {
-						fmt.Fprintf(os.Stderr, "ERROR: Generating Kitty protocol failed: %v\n", kittyErr)
-						// On error, print the un-rendered content as text
-						writer.WriteString("$$")
-						writer.WriteString(mathContent)
-						writer.WriteString("$$\n") // Add newline if it's missing from content
-					} else {
-						writer.WriteString(kittyStr) // Write the rendered image protocol
-					}
+					fmt.Fprintf(os.Stderr, "DEBUG: Queueing single-line display math: %s\n", strings.TrimSpace(mathContent))
 				}
+				shown := submitDisplayMath(inputLine[startMatchIdx[0]:startMatchIdx[1]], mathContent, inputLine[endMatchIdx[0]:endMatchIdx[1]])
 
 				// Process content *after* the end delimiter
 				afterDelimiter := inputLine[endMatchIdx[1]:]
-				if len(afterDelimiter) > 0 {
+				if len(afterDelimiter) > 0 && (shown || strings.TrimSpace(afterDelimiter) != "") {
 					if isDebugMode {
 						fmt.Fprintf(os.Stderr, "DEBUG: Processing text after single-line math: %s\n", strings.TrimSpace(afterDelimiter))
 					}
-					processedAfter := processInlineMath(afterDelimiter, effectivecolour, effectiveSize, effectiveDPI, effectiveFuzz, isDebugMode)
-					finalAfterOutput := markdown.ApplyFormatting(processedAfter)
-					writer.WriteString(finalAfterOutput)
+					submitText(afterDelimiter)
 				}
 
 			} else {
 				// No display math delimiters found on this line.
 				// Process for inline math and markdown as before.
-				processedLine := processInlineMath(inputLine, effectivecolour, effectiveSize, effectiveDPI, effectiveFuzz, isDebugMode)
+				line, defs := doc.text(inputLine)
+				output.Submit(func() string {
+					processedLine := processInlineMath(ctx, renderer, line, defs, effectivecolour, effectiveSize, effectiveDPI, useUnicode, isDebugMode)
 
-				// Apply Markdown formatting to the processed line.
-				finalLineOutput := markdown.ApplyFormatting(processedLine)
+					// Apply Markdown formatting to the processed line.
+					finalLineOutput := markdown.ApplyFormatting(processedLine)
 
-				// Remove any trailing special characters that might appear
-				finalLineOutput = strings.TrimSuffix(finalLineOutput, "%")
-				finalLineOutput = strings.TrimSuffix(finalLineOutput, "\x00")
+					// Remove any trailing special characters that might appear
+					finalLineOutput = strings.TrimSuffix(finalLineOutput, "%")
+					finalLineOutput = strings.TrimSuffix(finalLineOutput, "\x00")
 
-				// Make sure we keep newlines as is
-				if strings.HasSuffix(processedLine, "\n") && !strings.HasSuffix(finalLineOutput, "\n") {
-					finalLineOutput += "\n"
-				}
-
-				// Write the processed line to the buffered writer.
-				writer.WriteString(finalLineOutput)
+					// Make sure we keep newlines as is
+					if strings.HasSuffix(processedLine, "\n") && !strings.HasSuffix(finalLineOutput, "\n") {
+						finalLineOutput += "\n"
+					}
+					return finalLineOutput
+				})
 			}
 		}
 
-		// Flush the writer immediately after processing a line (or block end).
-		writer.Flush()
+		// Stop reading once interrupted; queued lines fall back to text
+		if ctx.Err() != nil {
+			if isDebugMode {
+				fmt.Fprintln(os.Stderr, "DEBUG: Interrupted, stopping input processing.")
+			}
+			break
+		}
 
 		// If the error was EOF, it means we just processed the last line.
 		// The loop condition should break after this iteration.
 		if isLastLine {
 			if isDebugMode {
-				fmt.Fprintln(os.Stderr, "DEBUG: Finished processing line before EOF. Exiting loop after flush.")
+				fmt.Fprintln(os.Stderr, "DEBUG: Finished processing line before EOF. Exiting loop.")
 			}
 			break
 		}
@@ -382,36 +762,193 @@ func processStreamingDocument(effectivecolour string, effectiveSize, effectiveDP
 		if isDebugMode {
 			fmt.Fprintln(os.Stderr, "DEBUG: Warning: Reached EOF while still inside a display math block. Outputting buffered content as plain text.")
 		}
-		writer.WriteString("$$") // Output the start delimiter that wasn't closed
-		writer.WriteString(mathBuffer.String()) // Output the buffered content
-		// No closing delimiter to output
-		writer.Flush()
+		// Output the start delimiter that wasn't closed and the buffered
+		// content; there is no closing delimiter to output
+		output.Write(mathOpen + mathBuffer.String())
+	}
+
+	// An unclosed environment is output as it was written
+	if mathEnvDepth > 0 {
+		if isDebugMode {
+			fmt.Fprintln(os.Stderr, "DEBUG: Warning: Reached EOF while still inside a math environment. Outputting buffered content as plain text.")
+		}
+		output.Write(mathBuffer.String())
 	}
 
+	// Wait for the remaining renders and write their output
+	output.Close()
+
 	if isDebugMode {
 		fmt.Fprintln(os.Stderr, "DEBUG: Output streaming finished.")
 	}
 }
 
-// processInlineMath handles inline math expressions in a text line
-func processInlineMath(line, effectivecolour string, effectiveSize, effectiveDPI int, effectiveFuzz string, isDebugMode bool) string {
+// renderDisplayMath renders a display math block to terminal output, with
+// its equation tag (if any) at the right edge of the terminal, falling back
+// to source, the block as written, if anything fails
+func renderDisplayMath(ctx context.Context, renderer *latex.Renderer, source, mathContent, macros, tag, effectivecolour string, effectiveSize, effectiveDPI int, isDebugMode bool) string {
+	fallback := source + "\n" // Add newline if it's missing from content
+	if tag != "" {
+		fallback = source + " " + tag + "\n"
+	}
+
+	if terminal.CurrentProtocol() == terminal.ProtocolText {
+		return fallback
+	}
+
+	img, renderErr := renderer.RenderExpr(ctx, latex.MathExpr{LaTeX: mathContent, Display: true, Macros: macros}, effectivecolour, effectiveDPI)
+	if renderErr != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Rendering display math failed: %v\n", renderErr)
+		debugCompileLog(renderErr, isDebugMode)
+		// On error, print the un-rendered content as text
+		return fallback
+	}
+	if isDebugMode {
+		fmt.Fprintln(os.Stderr, "DEBUG: Math rendering successful, generating terminal image")
+	}
+	imageStr, imageErr := terminal.Inline(img.PNG, true, effectiveSize)
+	if imageErr != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Generating terminal image failed: %v\n", imageErr)
+		// On error, print the un-rendered content as text
+		return fallback
+	}
+	if isDebugMode {
+		fmt.Fprintln(os.Stderr, "DEBUG: Successfully generated terminal image for display math")
+	}
+	if tag != "" {
+		imageStr += terminal.EquationTag(tag, terminal.ImageRows(img.Height, effectiveSize), terminal.Columns())
+	}
+	return imageStr // The rendered image protocol
+}
+
+// stdinIsFile reports whether standard input is redirected from a regular
+// file, in which case there is nothing to stream and batching is free
+func stdinIsFile() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// prerenderMath typesets every math expression in input in up to jobs
+// concurrent batches, so that the streaming pass finds them already rendered.
+// Expressions are collected in input order, with the macros and equation
+// numbers defined before them, as the streaming pass will ask for them.
+func prerenderMath(ctx context.Context, renderer *latex.Renderer, input, effectivecolour string, effectiveDPI, jobs int, useUnicode, isDebugMode bool) {
+	var exprs []latex.MathExpr
+	doc := newDocument(renderer.Unsafe())
+	addText := func(text string) {
+		for _, line := range strings.SplitAfter(text, "\n") {
+			line, defs := doc.text(line)
+			exprs = append(exprs, inlineMathExprs(line, defs, useUnicode)...)
+		}
+	}
+	for {
+		loc := nextDisplayMath(input)
+		if loc == nil {
+			addText(input)
+			break
+		}
+		addText(input[:loc[0]])
+		if content, defs, _, show := doc.displayMath(input[loc[2]:loc[3]]); show {
+			exprs = append(exprs, latex.MathExpr{LaTeX: content, Display: true, Macros: defs})
+		}
+		input = input[loc[1]:]
+	}
+
+	if jobs > len(exprs) {
+		jobs = len(exprs)
+	}
+	if isDebugMode {
+		fmt.Fprintf(os.Stderr, "DEBUG: Prerendering %d math expressions in %d batches\n", len(exprs), jobs)
+	}
+
+	// Failures are reported when the streaming pass renders them again
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		chunk := exprs[i*len(exprs)/jobs : (i+1)*len(exprs)/jobs]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			renderer.RenderBatch(ctx, chunk, effectivecolour, effectiveDPI)
+		}()
+	}
+	wg.Wait()
+}
+
+// nextDisplayMath returns the submatch indices of the first $$...$$ or
+// \[...\] block or bare math environment in s, or nil if there is none. The
+// content of an environment is the whole environment.
+func nextDisplayMath(s string) []int {
+	var first []int
+	for _, pattern := range []*regexp.Regexp{regex.DisplayMath, regex.DisplayMathBracket} {
+		if loc := pattern.FindStringSubmatchIndex(s); loc != nil && (first == nil || loc[0] < first[0]) {
+			first = loc
+		}
+	}
+	if loc := regex.BeginMathEnv.FindStringIndex(s); loc != nil && (first == nil || loc[0] < first[0]) {
+		if end, _ := mathEnvEnd(s[loc[0]:], 0); end >= 0 {
+			first = []int{loc[0], loc[0] + end, loc[0], loc[0] + end}
+		}
+	}
+	return first
+}
+
+// inlineMathExprs returns the inline expressions on a line that need LaTeX,
+// each to be typeset after macros
+func inlineMathExprs(line, macros string, useUnicode bool) []latex.MathExpr {
+	var exprs []latex.MathExpr
+	for _, pattern := range []*regexp.Regexp{regex.InlineMath, regex.InlineMathParen} {
+		for _, m := range pattern.FindAllStringSubmatch(line, -1) {
+			content := strings.TrimSpace(m[1])
+			if content == "" {
+				continue
+			}
+			if _, ok := unicode.Translate(content); useUnicode && ok {
+				continue
+			}
+			exprs = append(exprs, latex.MathExpr{LaTeX: content, Macros: macros})
+		}
+	}
+	return exprs
+}
+
+// processInlineMath handles inline math expressions in a text line, which
+// are typeset after the macro definitions in macros
+func processInlineMath(ctx context.Context, renderer *latex.Renderer, line, macros, effectivecolour string, effectiveSize, effectiveDPI int, useUnicode, isDebugMode bool) string {
+	// Without graphics only the Unicode fast path applies and other math
+	// is left as it was written
+	textOnly := terminal.CurrentProtocol() == terminal.ProtocolText
+
+	// Typeset all of the line's expressions in one LaTeX run; the closures
+	// below then pick up the results from the renderer
+	if exprs := inlineMathExprs(line, macros, useUnicode); len(exprs) > 1 && !textOnly {
+		renderer.RenderBatch(ctx, exprs, effectivecolour, effectiveDPI)
+	}
+
 	// Process $...$ inline math
 	processedLine := regex.InlineMath.ReplaceAllStringFunc(line, func(match string) string {
 		content := strings.TrimSpace(match[1 : len(match)-1])
 		if content == "" { return match }
 
+		if text, ok := translateUnicode(content, useUnicode, isDebugMode); ok {
+			return text
+		}
+		if textOnly {
+			return match
+		}
+
 		if isDebugMode {
 			fmt.Fprintf(os.Stderr, "DEBUG: Processing inline math with colour: '%s'\n", effectivecolour)
 		}
 
-		img, rErr := latex.RenderMath(content, effectivecolour, false, effectiveDPI, effectiveFuzz)
+		img, rErr := renderer.RenderExpr(ctx, latex.MathExpr{LaTeX: content, Macros: macros}, effectivecolour, effectiveDPI)
 		if rErr != nil {
 			fmt.Fprintf(os.Stderr, "Error rendering inline math ('%s'): %v\n", content, rErr)
+			debugCompileLog(rErr, isDebugMode)
 			return match
 		}
-		kStr, kErr := terminal.KittyInline(img, false, effectiveSize)
+		kStr, kErr := inlineImage(img, effectiveSize)
 		if kErr != nil {
-			fmt.Fprintf(os.Stderr, "Error generating Kitty protocol for inline math ('%s'): %v\n", content, kErr)
+			fmt.Fprintf(os.Stderr, "Error generating terminal image for inline math ('%s'): %v\n", content, kErr)
 			return match
 		}
 		return kStr
@@ -422,18 +959,26 @@ func processInlineMath(line, effectivecolour string, effectiveSize, effectiveDPI
 		content := strings.TrimSpace(match[2 : len(match)-2])
 		if content == "" { return match }
 
+		if text, ok := translateUnicode(content, useUnicode, isDebugMode); ok {
+			return text
+		}
+		if textOnly {
+			return match
+		}
+
 		if isDebugMode {
 			fmt.Fprintf(os.Stderr, "DEBUG: Processing parenthesis-style inline math with colour: '%s'\n", effectivecolour)
 		}
 
-		img, rErr := latex.RenderMath(content, effectivecolour, false, effectiveDPI, effectiveFuzz)
+		img, rErr := renderer.RenderExpr(ctx, latex.MathExpr{LaTeX: content, Macros: macros}, effectivecolour, effectiveDPI)
 		if rErr != nil {
 			fmt.Fprintf(os.Stderr, "Error rendering inline math ('%s'): %v\n", content, rErr)
+			debugCompileLog(rErr, isDebugMode)
 			return match
 		}
-		kStr, kErr := terminal.KittyInline(img, false, effectiveSize)
+		kStr, kErr := inlineImage(img, effectiveSize)
 		if kErr != nil {
-			fmt.Fprintf(os.Stderr, "Error generating Kitty protocol for inline math ('%s'): %v\n", content, kErr)
+			fmt.Fprintf(os.Stderr, "Error generating terminal image for inline math ('%s'): %v\n", content, kErr)
 			return match
 		}
 		return kStr
@@ -441,3 +986,36 @@ func processInlineMath(line, effectivecolour string, effectiveSize, effectiveDPI
 
 	return processedLine
 }
+
+// debugCompileLog prints the full LaTeX log or rasteriser output behind a
+// render error in debug mode; by default only the error's one-line summary
+// is shown
+func debugCompileLog(err error, isDebugMode bool) {
+	var compileErr *latex.CompileError
+	if isDebugMode && errors.As(err, &compileErr) {
+		fmt.Fprintf(os.Stderr, "DEBUG: %s log for %s:\n%s\n", compileErr.Engine, compileErr.What, compileErr.Log)
+	}
+}
+
+// translateUnicode applies the Unicode fast path to simple inline expressions
+func translateUnicode(content string, useUnicode, isDebugMode bool) (string, bool) {
+	if !useUnicode {
+		return "", false
+	}
+	text, ok := unicode.Translate(content)
+	if ok && isDebugMode {
+		fmt.Fprintf(os.Stderr, "DEBUG: Rendered inline math '%s' as Unicode '%s'\n", content, text)
+	}
+	return text, ok
+}
+
+// inlineImage generates the terminal output for an inline math image,
+// aligning its baseline with the text when the user hasn't fixed a size
+func inlineImage(img *latex.Image, effectiveSize int) (string, error) {
+	if effectiveSize == 0 && img.Depth >= 0 {
+		if cell, err := terminal.CachedCellSize(); err == nil {
+			return terminal.InlineAligned(img.PNG, img.Depth, cell)
+		}
+	}
+	return terminal.Inline(img.PNG, false, effectiveSize)
+}
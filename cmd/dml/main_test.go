@@ -2,11 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"dml/internal/latex"
+	"dml/internal/terminal"
 )
 
 // TestBasicExecution tests that the DML binary can be built and executed
@@ -96,7 +102,7 @@ func TestBasicExecution(t *testing.T) {
 }
 
 // TestMathRendering tests the math rendering capabilities
-// Note: This test requires LaTeX and ImageMagick to be installed
+// Note: This test requires LaTeX and a rasteriser (dvipng, pdftoppm or mutool) to be installed
 func TestMathRendering(t *testing.T) {
 	// Skip if the DML binary doesn't exist
 	if _, err := os.Stat("../../dml"); os.IsNotExist(err) {
@@ -113,9 +119,15 @@ func TestMathRendering(t *testing.T) {
 		t.Skip("pdflatex not found, skipping math rendering tests")
 	}
 
-	// Check if ImageMagick is installed
-	if _, err := exec.LookPath("convert"); err != nil {
-		t.Skip("convert (ImageMagick) not found, skipping math rendering tests")
+	// Check if a rasteriser is installed
+	hasRasteriser := false
+	for _, tool := range []string{"dvipng", "pdftoppm", "mutool"} {
+		if _, err := exec.LookPath(tool); err == nil {
+			hasRasteriser = true
+		}
+	}
+	if !hasRasteriser {
+		t.Skip("no rasteriser (dvipng, pdftoppm or mutool) found, skipping math rendering tests")
 	}
 
 	tests := []struct {
@@ -147,7 +159,8 @@ func TestMathRendering(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cmd := exec.Command("../../dml", tt.args...)
+			// Output is a pipe, where graphics are off unless asked for
+			cmd := exec.Command("../../dml", append([]string{"--protocol", "kitty"}, tt.args...)...)
 			cmd.Stdin = strings.NewReader(tt.input)
 			
 			stdout, err := cmd.StdoutPipe()
@@ -240,4 +253,112 @@ And some more text after.`
 	if len(output) == 0 {
 		t.Errorf("Expected non-empty output")
 	}
-}
\ No newline at end of file
+}
+
+// TestUserPreamble tests that config file and flag settings are combined
+func TestUserPreamble(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+
+	// Without a config file only the flags count
+	got, err := userPreamble("", []string{"physics"}, false)
+	if err != nil || got != `\usepackage{physics}` {
+		t.Errorf("userPreamble without config = %q, %v", got, err)
+	}
+
+	dir := filepath.Join(configHome, "dml")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"config":     "package = siunitx[per-mode=symbol]\npreamble = macros.tex\n",
+		"macros.tex": "\\newcommand{\\R}{\\mathbb{R}}\n",
+		"other.tex":  "\\newcommand{\\N}{\\mathbb{N}}\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err = userPreamble("", []string{"physics"}, false)
+	want := "\\usepackage[per-mode=symbol]{siunitx}\n\\usepackage{physics}\n\\newcommand{\\R}{\\mathbb{R}}"
+	if err != nil || got != want {
+		t.Errorf("userPreamble with config = %q, %v, want %q", got, err, want)
+	}
+
+	// --preamble replaces the config file's preamble
+	got, err = userPreamble(filepath.Join(dir, "other.tex"), nil, false)
+	if err != nil || strings.Contains(got, `\R`) || !strings.Contains(got, `\N`) {
+		t.Errorf("userPreamble with --preamble = %q, %v", got, err)
+	}
+
+	if _, err := userPreamble("", []string{"bad}name"}, false); err == nil {
+		t.Errorf("Expected error for invalid package")
+	}
+	if _, err := userPreamble(filepath.Join(dir, "missing.tex"), nil, false); err == nil {
+		t.Errorf("Expected error for missing preamble file")
+	}
+
+	// With nowhere to look for a config file, e.g. under env -i, there is none
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", "")
+	got, err = userPreamble("", []string{"physics"}, false)
+	if err != nil || got != `\usepackage{physics}` {
+		t.Errorf("userPreamble without a config location = %q, %v", got, err)
+	}
+}
+
+// TestRenderDisplayMathFallback tests that display math that isn't rendered
+// is printed as it was written, not as rewritten for LaTeX
+func TestRenderDisplayMathFallback(t *testing.T) {
+	terminal.SetProtocol(terminal.ProtocolText)
+	defer terminal.SetProtocol(terminal.ProtocolKitty)
+
+	source := `\begin{align} a &= b \label{eq:a} \end{align}`
+	got := renderDisplayMath(context.Background(), nil, source, `\begin{align*} a &= b  \end{align*}`, "", "(1)", "white", 0, 300, false)
+	if want := source + " (1)\n"; got != want {
+		t.Errorf("renderDisplayMath = %q, want %q", got, want)
+	}
+}
+
+// TestStreamingUnclosedDisplayMath tests that a display math block still
+// open at the end of the input is printed exactly as it was written
+func TestStreamingUnclosedDisplayMath(t *testing.T) {
+	terminal.SetProtocol(terminal.ProtocolText)
+	defer terminal.SetProtocol(terminal.ProtocolKitty)
+	renderer, err := latex.NewRenderer(latex.Options{})
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+
+	for _, input := range []string{
+		"Some text\n\\[ x^2\n+ y^2",
+		"\\[\n  x^2 \\quad\n\n",
+	} {
+		var out bytes.Buffer
+		processStreamingDocument(context.Background(), renderer, strings.NewReader(input), &out, "white", 0, 300, 1, false, false)
+		if got := out.String(); got != input {
+			t.Errorf("processStreamingDocument(%q) wrote %q", input, got)
+		}
+	}
+}
+
+// TestFullDocumentLaTeX tests that \[...\] stays display math in a full
+// document, so that it can be numbered
+func TestFullDocumentLaTeX(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"\\[ e^{i\\pi} = -1 \\label{eq:euler} \\]\n", `\begin{equation}e^{i\pi} = -1 \label{eq:euler}\end{equation}`},
+		{"\\[x^2\\]\n", `$$x^2$$`},
+		{"Since\n\\[\na = b \\tag{*}\n\\]\nwe are done.\n", `\begin{equation}a = b \tag{*}\end{equation}`},
+		{"Inline \\(y\\) math.\n", `Inline $y$ math.`},
+	}
+	for _, test := range tests {
+		if got := fullDocumentLaTeX(test.input); !strings.Contains(got, test.want) {
+			t.Errorf("fullDocumentLaTeX(%q) = %q, want it to contain %q", test.input, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Package main is the entry point for the DML tool
+package main
+
+import (
+	"bufio"
+	"io"
+)
+
+// outputQueue renders output segments on a bounded pool of goroutines and
+// writes them in submission order. Each segment is flushed as soon as it
+// and every segment before it are finished, so output keeps streaming.
+type outputQueue struct {
+	pending chan *segment // segments in submission order, awaiting output
+	slots   chan struct{} // one token per running render
+	done    chan struct{} // closed once the writer has drained pending
+}
+
+// segment is one piece of output, ready once text is set
+type segment struct {
+	ready chan struct{}
+	text  string
+}
+
+// newOutputQueue starts a queue that writes to w with at most jobs renders
+// running at once
+func newOutputQueue(w io.Writer, jobs int) *outputQueue {
+	if jobs < 1 {
+		jobs = 1
+	}
+	q := &outputQueue{
+		// Bound how far reading may run ahead of the output
+		pending: make(chan *segment, 4*jobs),
+		slots:   make(chan struct{}, jobs),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(q.done)
+		bw := bufio.NewWriter(w)
+		for seg := range q.pending {
+			<-seg.ready
+			bw.WriteString(seg.text)
+			bw.Flush()
+		}
+	}()
+	return q
+}
+
+// Submit queues the output of render, which runs on the pool. It blocks
+// while the pool or the queue is full.
+func (q *outputQueue) Submit(render func() string) {
+	seg := &segment{ready: make(chan struct{})}
+	q.pending <- seg
+	q.slots <- struct{}{}
+	go func() {
+		defer func() { <-q.slots }()
+		seg.text = render()
+		close(seg.ready)
+	}()
+}
+
+// Write queues text that needs no rendering
+func (q *outputQueue) Write(text string) {
+	seg := &segment{ready: make(chan struct{}), text: text}
+	close(seg.ready)
+	q.pending <- seg
+}
+
+// Close waits until every queued segment has been written
+func (q *outputQueue) Close() {
+	close(q.pending)
+	<-q.done
+}
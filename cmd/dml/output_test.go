@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOutputQueueOrder checks that segments finishing out of order are
+// still written in the order they were submitted
+func TestOutputQueueOrder(t *testing.T) {
+	var buf bytes.Buffer
+	q := newOutputQueue(&buf, 4)
+
+	var want strings.Builder
+	for i := 0; i < 12; i++ {
+		i := i
+		text := fmt.Sprintf("line %d\n", i)
+		want.WriteString(text)
+		if i%3 == 0 {
+			q.Write(text)
+			continue
+		}
+		q.Submit(func() string {
+			// Earlier segments take longest
+			time.Sleep(time.Duration(12-i) * time.Millisecond)
+			return text
+		})
+	}
+	q.Close()
+
+	if buf.String() != want.String() {
+		t.Errorf("Output out of order:\n%s\nwant:\n%s", buf.String(), want.String())
+	}
+}
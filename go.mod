@@ -5,9 +5,6 @@ go 1.18
 require (
 	github.com/BourgeoisBear/rasterm v1.1.1
 	github.com/gomarkdown/markdown v0.0.0-20250311123330-531bef5e742b
-)
-
-require (
-	golang.org/x/sys v0.18.0 // indirect
-	golang.org/x/term v0.18.0 // indirect
+	golang.org/x/sys v0.18.0
+	golang.org/x/term v0.18.0
 )
@@ -0,0 +1,425 @@
+// Package cache provides a persistent on-disk LRU cache for rendered images
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBytes is the default limit on the total size of cached PNGs (100 MB)
+const DefaultMaxBytes int64 = 100 * 1024 * 1024
+
+// Meta is the JSON metadata stored alongside each cached PNG
+type Meta struct {
+	Key        string    `json:"key"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	Depth      int       `json:"depth"` // baseline offset from the bottom edge in pixels, -1 if unknown
+	Size       int64     `json:"size"`
+	Created    time.Time `json:"created"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// accessWriteInterval is how stale an entry's on-disk last-access time may
+// get before Get rewrites its metadata. Anything finer is kept in memory and
+// written by Flush, so a hit doesn't normally cost a file write.
+const accessWriteInterval = time.Hour
+
+// statsFile holds hit/miss counters accumulated across dml runs
+const statsFile = "stats.json"
+
+// Stats summarises the state and effectiveness of the cache
+type Stats struct {
+	Dir      string
+	Entries  int
+	Bytes    int64
+	MaxBytes int64
+	Hits     int64
+	Misses   int64
+}
+
+// persistedStats is the on-disk form of the hit/miss counters
+type persistedStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// Cache is a disk-backed LRU cache of PNG images keyed by content hash.
+// It is safe for concurrent use within a single process.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*Meta
+	total   int64
+	hits    int64
+	misses  int64
+
+	// unsaved holds the on-disk last-access time of entries whose
+	// in-memory time is newer and not yet written
+	unsaved map[string]time.Time
+}
+
+// DefaultDir returns the default cache location (~/.cache/dml on Linux)
+func DefaultDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "dml"), nil
+}
+
+// Key returns the hex SHA-256 of the given parts, separated so that
+// ("ab", "c") and ("a", "bc") hash differently
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Open opens (creating if necessary) the cache in dir and loads its index.
+// A maxBytes of zero or less selects DefaultMaxBytes.
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating cache directory '%s': %v", dir, err)
+	}
+
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*Meta),
+		unsaved:  make(map[string]time.Time),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading cache directory '%s': %v", dir, err)
+	}
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, ".json") || !isKey(strings.TrimSuffix(name, ".json")) {
+			continue
+		}
+		key := strings.TrimSuffix(name, ".json")
+		meta, err := c.readMeta(key)
+		if err != nil {
+			// Unreadable metadata: drop the entry rather than fail
+			c.removeFiles(key)
+			continue
+		}
+		c.entries[key] = meta
+		c.total += meta.Size
+	}
+
+	// The limit may have been lowered since the last run
+	c.mu.Lock()
+	c.evictLocked()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+// Dir returns the directory backing the cache
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Get returns the cached PNG and its metadata for key, updating its access time
+func (c *Cache) Get(key string) ([]byte, *Meta, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	meta, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, nil, false
+	}
+
+	data, err := ioutil.ReadFile(c.pngPath(key))
+	if err != nil {
+		// The PNG vanished underneath us; forget the entry
+		c.dropLocked(key)
+		c.misses++
+		return nil, nil, false
+	}
+
+	now := time.Now()
+	saved, ok := c.unsaved[key]
+	if !ok {
+		saved = meta.LastAccess
+	}
+	meta.LastAccess = now
+	if now.Sub(saved) >= accessWriteInterval {
+		c.writeMeta(meta) // best effort; the in-memory index is authoritative
+		delete(c.unsaved, key)
+	} else {
+		c.unsaved[key] = saved
+	}
+	c.hits++
+
+	copied := *meta
+	return data, &copied, true
+}
+
+// Put stores data under key and evicts least-recently-used entries if the
+// cache has grown beyond its size limit. Width, height, size and timestamps
+// in meta are filled in by the cache.
+func (c *Cache) Put(key string, data []byte, meta Meta) error {
+	if !isKey(key) {
+		return fmt.Errorf("invalid cache key '%s'", key)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("refusing to cache invalid PNG: %v", err)
+	}
+
+	now := time.Now()
+	meta.Key = key
+	meta.Width = cfg.Width
+	meta.Height = cfg.Height
+	meta.Size = int64(len(data))
+	meta.Created = now
+	meta.LastAccess = now
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := writeFileAtomic(c.pngPath(key), data); err != nil {
+		return err
+	}
+	if err := c.writeMeta(&meta); err != nil {
+		os.Remove(c.pngPath(key))
+		return err
+	}
+
+	if old, ok := c.entries[key]; ok {
+		c.total -= old.Size
+	}
+	c.entries[key] = &meta
+	c.total += meta.Size
+
+	c.evictLocked()
+	return nil
+}
+
+// Stats returns current cache statistics, including hit/miss counts from
+// previous runs that have been recorded with Flush
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	persisted := c.readStats()
+	return Stats{
+		Dir:      c.dir,
+		Entries:  len(c.entries),
+		Bytes:    c.total,
+		MaxBytes: c.maxBytes,
+		Hits:     persisted.Hits + c.hits,
+		Misses:   persisted.Misses + c.misses,
+	}
+}
+
+// Flush writes any pending access times and adds this run's hit/miss
+// counts to the persisted statistics
+func (c *Cache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.unsaved {
+		if m, ok := c.entries[key]; ok {
+			c.writeMeta(m) // best effort, as in Get
+		}
+		delete(c.unsaved, key)
+	}
+
+	if c.hits == 0 && c.misses == 0 {
+		return nil
+	}
+	persisted := c.readStats()
+	persisted.Hits += c.hits
+	persisted.Misses += c.misses
+	raw, err := json.MarshalIndent(persisted, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(c.dir, statsFile), raw); err != nil {
+		return err
+	}
+	c.hits, c.misses = 0, 0
+	return nil
+}
+
+// Clear removes every entry and resets the statistics
+func (c *Cache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.entries {
+		c.dropLocked(key)
+	}
+	c.hits, c.misses = 0, 0
+
+	// Also sweep files the index doesn't know about (partial writes, other versions)
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, f := range files {
+		name := f.Name()
+		isEntry := (strings.HasSuffix(name, ".png") || strings.HasSuffix(name, ".json")) &&
+			isKey(strings.TrimSuffix(strings.TrimSuffix(name, ".png"), ".json"))
+		if isEntry || name == statsFile || strings.HasPrefix(name, ".tmp-") {
+			if err := os.Remove(filepath.Join(c.dir, name)); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Prune removes entries that have not been used for longer than maxAge,
+// returning the number of entries removed and the bytes freed
+func (c *Cache) Prune(maxAge time.Duration) (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	var freed int64
+	for key, m := range c.entries {
+		if m.LastAccess.Before(cutoff) {
+			freed += m.Size
+			removed++
+			c.dropLocked(key)
+		}
+	}
+	return removed, freed
+}
+
+func (c *Cache) readStats() persistedStats {
+	var persisted persistedStats
+	raw, err := ioutil.ReadFile(filepath.Join(c.dir, statsFile))
+	if err == nil {
+		json.Unmarshal(raw, &persisted) // a corrupt file just restarts the counters
+	}
+	return persisted
+}
+
+// evictLocked removes least-recently-used entries until the total size fits
+func (c *Cache) evictLocked() {
+	if c.total <= c.maxBytes {
+		return
+	}
+
+	metas := make([]*Meta, 0, len(c.entries))
+	for _, m := range c.entries {
+		metas = append(metas, m)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].LastAccess.Before(metas[j].LastAccess)
+	})
+
+	for _, m := range metas {
+		if c.total <= c.maxBytes {
+			break
+		}
+		c.dropLocked(m.Key)
+	}
+}
+
+// dropLocked removes an entry from both the index and the disk
+func (c *Cache) dropLocked(key string) {
+	if m, ok := c.entries[key]; ok {
+		c.total -= m.Size
+		delete(c.entries, key)
+	}
+	delete(c.unsaved, key)
+	c.removeFiles(key)
+}
+
+func (c *Cache) removeFiles(key string) {
+	os.Remove(c.pngPath(key))
+	os.Remove(c.metaPath(key))
+}
+
+func (c *Cache) readMeta(key string) (*Meta, error) {
+	raw, err := ioutil.ReadFile(c.metaPath(key))
+	if err != nil {
+		return nil, err
+	}
+	var meta Meta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, err
+	}
+	if meta.Key != key {
+		return nil, fmt.Errorf("metadata key mismatch for '%s'", key)
+	}
+	return &meta, nil
+}
+
+func (c *Cache) writeMeta(meta *Meta) error {
+	raw, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.metaPath(meta.Key), raw)
+}
+
+func (c *Cache) pngPath(key string) string {
+	return filepath.Join(c.dir, key+".png")
+}
+
+func (c *Cache) metaPath(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// writeFileAtomic writes via a temp file and rename so readers (including
+// other dml processes) never see a partially written entry
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// isKey reports whether s looks like a key produced by Key
+func isKey(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
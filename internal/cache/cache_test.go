@@ -0,0 +1,276 @@
+package cache
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makePNG encodes a blank w x h image for use as cache payload
+func makePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("png.Encode failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestKey(t *testing.T) {
+	if Key("a", "bc") == Key("ab", "c") {
+		t.Errorf("Key should separate parts")
+	}
+	if Key("x^2", "white") != Key("x^2", "white") {
+		t.Errorf("Key should be deterministic")
+	}
+	if !isKey(Key("x")) {
+		t.Errorf("Key output should be recognised by isKey")
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	key := Key("E=mc^2", "white", "300")
+	data := makePNG(t, 4, 3)
+
+	if _, _, ok := c.Get(key); ok {
+		t.Fatalf("Expected miss on empty cache")
+	}
+	if err := c.Put(key, data, Meta{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	got, meta, ok := c.Get(key)
+	if !ok {
+		t.Fatalf("Expected hit after Put")
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Cached data differs from stored data")
+	}
+	if meta.Width != 4 || meta.Height != 3 {
+		t.Errorf("Meta dimensions = %dx%d, want 4x3", meta.Width, meta.Height)
+	}
+	if meta.Size != int64(len(data)) {
+		t.Errorf("Meta size = %d, want %d", meta.Size, len(data))
+	}
+
+	for _, name := range []string{key + ".png", key + ".json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s on disk: %v", name, err)
+		}
+	}
+
+	// A fresh handle on the same directory should see the entry
+	reopened, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if _, _, ok := reopened.Get(key); !ok {
+		t.Errorf("Expected entry to persist across Open")
+	}
+}
+
+func TestPutRejectsInvalid(t *testing.T) {
+	c, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := c.Put("not-a-key", makePNG(t, 1, 1), Meta{}); err == nil {
+		t.Errorf("Expected error for malformed key")
+	}
+	if err := c.Put(Key("x"), []byte("not a png"), Meta{}); err == nil {
+		t.Errorf("Expected error for non-PNG data")
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	data := makePNG(t, 8, 8)
+	size := int64(len(data))
+
+	// Room for two entries but not three
+	c, err := Open(t.TempDir(), size*2+size/2)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	keyA, keyB, keyC := Key("a"), Key("b"), Key("c")
+	if err := c.Put(keyA, data, Meta{}); err != nil {
+		t.Fatalf("Put a failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := c.Put(keyB, data, Meta{}); err != nil {
+		t.Fatalf("Put b failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// Touch a so that b becomes the least recently used
+	if _, _, ok := c.Get(keyA); !ok {
+		t.Fatalf("Expected hit for a")
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if err := c.Put(keyC, data, Meta{}); err != nil {
+		t.Fatalf("Put c failed: %v", err)
+	}
+
+	if _, _, ok := c.Get(keyB); ok {
+		t.Errorf("Expected b to be evicted")
+	}
+	if _, _, ok := c.Get(keyA); !ok {
+		t.Errorf("Expected a to survive eviction")
+	}
+	if _, _, ok := c.Get(keyC); !ok {
+		t.Errorf("Expected c to survive eviction")
+	}
+}
+
+func TestMissingPNGIsMiss(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	key := Key("gone")
+	if err := c.Put(key, makePNG(t, 1, 1), Meta{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	os.Remove(filepath.Join(dir, key+".png"))
+
+	if _, _, ok := c.Get(key); ok {
+		t.Errorf("Expected miss when PNG has been removed")
+	}
+}
+
+func TestStatsPersistAcrossFlush(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	key := Key("x")
+	c.Get(key) // miss
+	if err := c.Put(key, makePNG(t, 2, 2), Meta{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	c.Get(key) // hit
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	reopened, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	reopened.Get(key) // hit in the new run, not yet flushed
+
+	st := reopened.Stats()
+	if st.Hits != 2 || st.Misses != 1 {
+		t.Errorf("Stats hits/misses = %d/%d, want 2/1", st.Hits, st.Misses)
+	}
+	if st.Entries != 1 {
+		t.Errorf("Stats entries = %d, want 1", st.Entries)
+	}
+	if st.Bytes <= 0 {
+		t.Errorf("Stats bytes = %d, want > 0", st.Bytes)
+	}
+}
+
+func TestGetDefersAccessWrite(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	key := Key("lazy")
+	if err := c.Put(key, makePNG(t, 1, 1), Meta{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	stored, err := c.readMeta(key)
+	if err != nil {
+		t.Fatalf("readMeta failed: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	c.Get(key)
+	onDisk, _ := c.readMeta(key)
+	if !onDisk.LastAccess.Equal(stored.LastAccess) {
+		t.Errorf("Get rewrote metadata for a recently written entry")
+	}
+
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	onDisk, _ = c.readMeta(key)
+	if !onDisk.LastAccess.After(stored.LastAccess) {
+		t.Errorf("Flush did not write the new access time")
+	}
+
+	// A stored time past the interval is rewritten straight away
+	c.entries[key].LastAccess = time.Now().Add(-2 * accessWriteInterval)
+	c.Get(key)
+	onDisk, _ = c.readMeta(key)
+	if time.Since(onDisk.LastAccess) > time.Minute {
+		t.Errorf("Get did not rewrite a stale access time")
+	}
+}
+
+func TestClear(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, k := range []string{"a", "b"} {
+		if err := c.Put(Key(k), makePNG(t, 1, 1), Meta{}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	c.Get(Key("a"))
+	c.Flush()
+
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	st := c.Stats()
+	if st.Entries != 0 || st.Bytes != 0 || st.Hits != 0 || st.Misses != 0 {
+		t.Errorf("Stats after Clear = %+v, want empty", st)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("Expected empty cache directory after Clear, found %d files", len(files))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	c, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	oldKey, newKey := Key("old"), Key("new")
+	for _, k := range []string{oldKey, newKey} {
+		if err := c.Put(k, makePNG(t, 1, 1), Meta{}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	c.entries[oldKey].LastAccess = time.Now().Add(-48 * time.Hour)
+
+	removed, freed := c.Prune(24 * time.Hour)
+	if removed != 1 || freed <= 0 {
+		t.Errorf("Prune removed %d entries (%d bytes), want 1 entry", removed, freed)
+	}
+	if _, _, ok := c.Get(oldKey); ok {
+		t.Errorf("Expected stale entry to be pruned")
+	}
+	if _, _, ok := c.Get(newKey); !ok {
+		t.Errorf("Expected recent entry to survive pruning")
+	}
+}
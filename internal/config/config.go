@@ -0,0 +1,93 @@
+// Package config reads the DML configuration file
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config holds the settings read from a configuration file
+type Config struct {
+	Preamble string   // path of a file of LaTeX to add to the preamble
+	Packages []string // package specs, NAME or NAME[options]
+}
+
+// DefaultPath returns the location of the configuration file,
+// ~/.config/dml/config on Linux
+func DefaultPath() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "dml", "config"), nil
+}
+
+// Load reads the configuration file at path. A missing file is an empty
+// configuration, not an error.
+//
+// The file holds one "key = value" setting per line; blank lines and lines
+// starting with # are ignored. The keys are "preamble", a file whose LaTeX
+// is added to the preamble (relative paths are resolved against the config
+// file's directory, and ~/ against the home directory), and "package",
+// which may be repeated.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := &Config{}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNum)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if value == "" {
+			return nil, fmt.Errorf("%s:%d: missing value for %s", path, lineNum, key)
+		}
+
+		switch key {
+		case "preamble":
+			cfg.Preamble, err = resolvePath(value, filepath.Dir(path))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+			}
+		case "package":
+			cfg.Packages = append(cfg.Packages, value)
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown setting '%s'", path, lineNum, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// resolvePath expands a leading ~/ and makes a relative path relative to dir
+func resolvePath(path, dir string) (string, error) {
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, path[2:]), nil
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(dir, path), nil
+	}
+	return path, nil
+}
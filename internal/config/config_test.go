@@ -0,0 +1,89 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes content to a config file in a temp dir and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `# Physics notes
+preamble = macros.tex
+package = physics
+
+package=siunitx[per-mode=symbol]
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if want := filepath.Join(filepath.Dir(path), "macros.tex"); cfg.Preamble != want {
+		t.Errorf("Preamble = %q, want %q", cfg.Preamble, want)
+	}
+	if want := []string{"physics", "siunitx[per-mode=symbol]"}; !reflect.DeepEqual(cfg.Packages, want) {
+		t.Errorf("Packages = %q, want %q", cfg.Packages, want)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), "config"))
+	if err != nil {
+		t.Fatalf("Load of a missing file failed: %v", err)
+	}
+	if cfg.Preamble != "" || len(cfg.Packages) != 0 {
+		t.Errorf("Load of a missing file = %+v, want empty", cfg)
+	}
+}
+
+func TestLoadHomePath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	cfg, err := Load(writeConfig(t, "preamble = ~/tex/macros.tex\n"))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if want := filepath.Join(home, "tex", "macros.tex"); cfg.Preamble != want {
+		t.Errorf("Preamble = %q, want %q", cfg.Preamble, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"preamble\n", ":1: expected key = value"},
+		{"\npackage =\n", ":2: missing value for package"},
+		{"colour = red\n", ":1: unknown setting 'colour'"},
+	}
+
+	for _, test := range tests {
+		_, err := Load(writeConfig(t, test.content))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Load(%q) error = %v, want %q", test.content, err, test.want)
+		}
+	}
+}
+
+func TestDefaultPath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/tmp/xdg")
+	path, err := DefaultPath()
+	if err != nil {
+		t.Fatalf("DefaultPath failed: %v", err)
+	}
+	if !strings.HasSuffix(path, filepath.Join("dml", "config")) {
+		t.Errorf("DefaultPath = %q, want .../dml/config", path)
+	}
+}
@@ -0,0 +1,294 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// MathExpr is one math expression for RenderBatch
+type MathExpr struct {
+	LaTeX   string
+	Display bool
+	Macros  string // definitions typeset before the expression, from Macros.String()
+}
+
+// batchItem is an expression that still needs typesetting
+type batchItem struct {
+	index   int // position in the RenderBatch input
+	latex   string
+	display bool
+	macros  string
+	key     string
+}
+
+// RenderBatch renders several math expressions, returning an image or an
+// error for each. Expressions missing from the cache are typeset together
+// as the pages of a single LaTeX document. If that document fails to
+// compile it is split in half and each half retried, until the expressions
+// that break it are isolated and rendered on their own; if it times out,
+// the expressions are rendered one by one within one more timeout. Once ctx
+// is done, expressions still to be typeset fail with its error.
+func (r *Renderer) RenderBatch(ctx context.Context, exprs []MathExpr, colourStr string, dpi int) ([]*Image, []error) {
+	imgs := make([]*Image, len(exprs))
+	errs := make([]error, len(exprs))
+
+	var pending []batchItem
+	firstByKey := make(map[string]int)
+	duplicates := make(map[int]int) // index -> index of the identical pending expression
+	for i, e := range exprs {
+		latex := strings.TrimSpace(e.LaTeX)
+		if latex == "" {
+			errs[i] = fmt.Errorf("empty LaTeX content")
+			continue
+		}
+		if !r.unsafe {
+			// Definitions were checked against the length limit with the
+			// expressions they came from
+			err := checkSafe(e.Macros, 0)
+			if err == nil {
+				err = checkSafe(latex, MaxSafeLength)
+			}
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+		}
+
+		// Add a small amount of spacing around display math for better rendering
+		if e.Display {
+			latex = " " + latex + " "
+		}
+
+		key := r.mathCacheKey(e.Macros, latex, colourStr, e.Display, dpi)
+		if res, ok := r.lookupRender(key); ok {
+			if isDebug {
+				fmt.Fprintf(os.Stderr, "DEBUG: Cache hit for '%s' (%s)\n", latex, key[:12])
+			}
+			imgs[i], errs[i] = res.img, res.err
+			continue
+		}
+		if first, ok := firstByKey[key]; ok {
+			duplicates[i] = first
+			continue
+		}
+		firstByKey[key] = i
+		pending = append(pending, batchItem{index: i, latex: latex, display: e.Display, macros: e.Macros, key: key})
+	}
+
+	if isDebug && len(exprs) > 1 {
+		fmt.Fprintf(os.Stderr, "DEBUG: Batch of %d expressions, %d to typeset\n", len(exprs), len(pending))
+	}
+
+	r.renderItems(ctx, pending, colourStr, dpi, imgs, errs)
+
+	for i, first := range duplicates {
+		imgs[i], errs[i] = imgs[first], errs[first]
+	}
+	return imgs, errs
+}
+
+// renderItems typesets items in one document, bisecting on failure and
+// falling back to renderEach on a timeout
+func (r *Renderer) renderItems(ctx context.Context, items []batchItem, colourStr string, dpi int, imgs []*Image, errs []error) {
+	if len(items) == 0 {
+		return
+	}
+
+	rendered, err := r.compileBatch(ctx, items, colourStr, dpi)
+	if err == nil {
+		for j, item := range items {
+			imgs[item.index] = rendered[j]
+			r.storeRender(item.key, rendered[j])
+		}
+		return
+	}
+
+	// Cancellation is not the expressions' fault, so it isn't remembered
+	if ctx.Err() != nil {
+		for _, item := range items {
+			errs[item.index] = err
+		}
+		return
+	}
+
+	if len(items) == 1 {
+		errs[items[0].index] = err
+		r.rememberRender(items[0].key, nil, err)
+		return
+	}
+
+	// Bisecting would give every half the full timeout again, so an
+	// expression that never finishes would hold the batch up once per level
+	if errors.Is(err, ErrTimeout) {
+		if isDebug {
+			fmt.Fprintf(os.Stderr, "DEBUG: Batch of %d expressions timed out, rendering them one by one: %v\n", len(items), err)
+		}
+		r.renderEach(ctx, items, colourStr, dpi, imgs, errs)
+		return
+	}
+
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Batch of %d expressions failed, splitting: %v\n", len(items), err)
+	}
+	mid := len(items) / 2
+	r.renderItems(ctx, items[:mid], colourStr, dpi, imgs, errs)
+	r.renderItems(ctx, items[mid:], colourStr, dpi, imgs, errs)
+}
+
+// renderEach typesets items one at a time after their batch timed out. The
+// items share a single timeout, so time spent on one is counted against
+// the rest, and a batch holding an expression that never finishes takes at
+// most twice the timeout. An item that runs out of time it had to share
+// isn't to blame, so its timeout is not remembered.
+func (r *Renderer) renderEach(ctx context.Context, items []batchItem, colourStr string, dpi int, imgs []*Image, errs []error) {
+	deadline := time.Now().Add(r.timeout)
+	for j, item := range items {
+		itemCtx := ctx
+		if j > 0 {
+			itemCtx = withDeadline(ctx, deadline)
+		}
+		rendered, err := r.compileBatch(itemCtx, []batchItem{item}, colourStr, dpi)
+		switch {
+		case err == nil:
+			imgs[item.index] = rendered[0]
+			r.storeRender(item.key, rendered[0])
+		case ctx.Err() != nil, j > 0 && errors.Is(err, ErrTimeout):
+			errs[item.index] = err
+		default:
+			errs[item.index] = err
+			r.rememberRender(item.key, nil, err)
+		}
+	}
+}
+
+// compileBatch typesets every item as its own page (or pair of pages for
+// the PDF rasteriser) of one document and splits the result into images
+func (r *Renderer) compileBatch(ctx context.Context, items []batchItem, colourStr string, dpi int) (imgs []*Image, err error) {
+	textColour, latexcolourDefs := textColourDefs(colourStr)
+
+	// Build one page per expression. dvipng renders onto a transparent page;
+	// PDFs are rendered on black and on white to recover the transparency
+	// exactly. Inline metrics are tagged with the item's position.
+	var pages strings.Builder
+	for j, item := range items {
+		var mathContent string
+		if item.display && isMathEnvironment(item.latex) {
+			mathContent = item.latex // Already display math, like align*
+		} else if item.display {
+			mathContent = fmt.Sprintf(`\[%s\]`, item.latex)
+		} else {
+			mathContent = measuredInlineMath(j, item.latex)
+		}
+		// Definitions are local to the page, like everything else on it
+		mathContent = item.macros + mathContent
+		if r.rasteriser == RasteriserDVIPNG {
+			pages.WriteString(texPage("", textColour, mathContent))
+		} else {
+			pages.WriteString(dualPages(textColour, mathContent))
+		}
+	}
+
+	// With a precompiled format the preamble is already loaded
+	format := r.mathFormat(ctx)
+	tex := fmt.Sprintf(TexTemplate, r.docPreamble(false), latexcolourDefs, pages.String())
+	if format != "" {
+		tex = fmt.Sprintf(mathBody, latexcolourDefs, pages.String())
+	}
+
+	// Create temporary directory, removed when done unless it is being kept
+	dir, err := newTempDir("dml")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { r.releaseTempDir(dir, err != nil) }()
+
+	texFile := dir + "/eq.tex"
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Created temp directory for LaTeX rendering of %d expressions: %s\n", len(items), dir)
+	}
+
+	// Write the TeX file
+	if err := ioutil.WriteFile(texFile, []byte(tex), 0644); err != nil {
+		return nil, err
+	}
+
+	// Compile to PDF, or to DVI for dvipng
+	if err := r.runEngine(ctx, dir, texFile, r.rasteriser == RasteriserDVIPNG, format, describeItems(items)); err != nil {
+		return nil, err
+	}
+
+	// Rasterise the compiled output
+	display := make([]bool, len(items))
+	for j, item := range items {
+		display[j] = item.display
+	}
+	if r.rasteriser == RasteriserDVIPNG {
+		imgs, err = r.rasteriseDVI(ctx, dir, dpi, display)
+	} else {
+		imgs, err = r.rasterisePDF(ctx, dir, dpi, display)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return imgs, nil
+}
+
+// describeItems names the expressions of a batch for error messages
+func describeItems(items []batchItem) string {
+	if len(items) == 1 {
+		return fmt.Sprintf("'%s'", items[0].latex)
+	}
+	return fmt.Sprintf("batch of %d expressions", len(items))
+}
+
+// rasterisePDF rasterises the black and white page pairs of dir/eq.pdf in
+// one run and combines each pair into one transparent image
+func (r *Renderer) rasterisePDF(ctx context.Context, dir string, dpi int, display []bool) ([]*Image, error) {
+	var metrics map[int]boxMetrics
+	var metricsErr error
+	for _, d := range display {
+		if !d {
+			metrics, metricsErr = readBoxMetrics(dir + "/eq.log")
+			break
+		}
+	}
+
+	// Display math is trimmed to its ink; inline math keeps the full page so
+	// that the baseline position computed from the box metrics stays valid
+	imgData, err := r.renderDualPDF(ctx, dir+"/eq.pdf", dpi, display)
+	if err != nil {
+		return nil, err
+	}
+
+	imgs := make([]*Image, len(display))
+	for j, isDisplay := range display {
+		img, err := newImage(imgData[j], -1)
+		if err != nil {
+			return nil, err
+		}
+
+		// Locate the baseline of inline math from the box metrics in the log
+		if !isDisplay {
+			m, ok := metrics[j]
+			if ok {
+				img.Depth = depthPixels(img.Height, m.height, m.depth, mathBorderPt)
+			}
+			if isDebug {
+				if !ok {
+					fmt.Fprintf(os.Stderr, "DEBUG: Baseline unknown for expression %d: %v\n", j, metricsErr)
+				} else {
+					fmt.Fprintf(os.Stderr, "DEBUG: Box height %.2fpt, depth %.2fpt -> baseline %dpx above bottom of %dpx image\n",
+						m.height, m.depth, img.Depth, img.Height)
+				}
+			}
+		}
+		imgs[j] = img
+	}
+	return imgs, nil
+}
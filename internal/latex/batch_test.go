@@ -0,0 +1,156 @@
+package latex
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRenderBatchMemo(t *testing.T) {
+	// With no TeX engine on PATH every compile fails, so only expressions
+	// already rendered during the run can succeed
+	t.Setenv("PATH", t.TempDir())
+	r, err := NewRenderer(Options{Rasteriser: RasteriserPDF})
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+
+	known := &Image{PNG: []byte("png"), Width: 1, Height: 1, Depth: 0}
+	r.rememberRender(r.mathCacheKey("", "x^2", "white", false, 300), known, nil)
+
+	exprs := []MathExpr{
+		{LaTeX: " x^2 "},
+		{LaTeX: ""},
+		{LaTeX: `\frac{a}{b}`},
+		{LaTeX: `\frac{a}{b}`},
+		{LaTeX: `\sqrt{2}`, Display: true},
+	}
+	imgs, errs := r.RenderBatch(context.Background(), exprs, "white", 300)
+
+	if imgs[0] != known || errs[0] != nil {
+		t.Errorf("Expression 0: got (%v, %v), want remembered image", imgs[0], errs[0])
+	}
+	if errs[1] == nil {
+		t.Errorf("Expression 1: expected error for empty LaTeX")
+	}
+	for i := 2; i < len(exprs); i++ {
+		if imgs[i] != nil || errs[i] == nil {
+			t.Errorf("Expression %d: got (%v, %v), want compile error", i, imgs[i], errs[i])
+		}
+	}
+	if errs[2] != errs[3] {
+		t.Errorf("Duplicate expressions should share one result")
+	}
+
+	// Failures are remembered so broken expressions are not recompiled
+	if res, ok := r.lookupRender(r.mathCacheKey("", `\frac{a}{b}`, "white", false, 300)); !ok || res.err == nil {
+		t.Errorf("Expected remembered failure, got (%+v, %v)", res, ok)
+	}
+}
+
+func TestRenderBatchConcurrent(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	r, err := NewRenderer(Options{Rasteriser: RasteriserPDF})
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+
+	// Renders from many goroutines share the renderer's memo
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.RenderMath(context.Background(), `\alpha`, "white", false, 300); err == nil {
+				t.Errorf("Expected compile error with empty PATH")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if res, ok := r.lookupRender(r.mathCacheKey("", `\alpha`, "white", false, 300)); !ok || res.err == nil {
+		t.Errorf("Expected remembered failure, got (%+v, %v)", res, ok)
+	}
+}
+
+func TestRenderMemoLimit(t *testing.T) {
+	r, err := NewRenderer(Options{Rasteriser: RasteriserPDF})
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+
+	img := &Image{PNG: []byte("png"), Width: 1, Height: 1}
+	for i := 0; i < memoLimit; i++ {
+		r.rememberRender(strconv.Itoa(i), img, nil)
+	}
+	// Using the oldest outcome keeps it, so the next one is forgotten instead
+	if _, ok := r.lookupRender("0"); !ok {
+		t.Fatalf("Expected outcome 0 to be remembered")
+	}
+	r.rememberRender("new", img, nil)
+
+	if len(r.memo) != memoLimit || r.memoOrder.Len() != memoLimit {
+		t.Errorf("Memo holds %d outcomes (%d in order), want %d", len(r.memo), r.memoOrder.Len(), memoLimit)
+	}
+	if _, ok := r.lookupRender("1"); ok {
+		t.Errorf("Expected the least recently used outcome to be forgotten")
+	}
+	for _, key := range []string{"0", "2", "new"} {
+		if _, ok := r.lookupRender(key); !ok {
+			t.Errorf("Expected outcome %s to be remembered", key)
+		}
+	}
+}
+
+func TestRenderBatchTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	// A stand-in pdflatex that hangs on \loop and fails at once otherwise
+	bin := t.TempDir()
+	script := "#!/bin/sh\nfor f; do :; done\nif grep -q loop \"$f\"; then exec sleep 30; fi\nexit 1\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "pdflatex"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+":/bin:/usr/bin")
+
+	timeout := 300 * time.Millisecond
+	r, err := NewRenderer(Options{Rasteriser: RasteriserPDF, Timeout: timeout})
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+
+	exprs := []MathExpr{{LaTeX: "a"}, {LaTeX: "b"}, {LaTeX: `\loop`}, {LaTeX: "c"}, {LaTeX: "d"}}
+	start := time.Now()
+	_, errs := r.RenderBatch(context.Background(), exprs, "white", 300)
+	elapsed := time.Since(start)
+
+	// Bisecting would take a timeout per level; one by one takes at most two
+	if elapsed > 2*timeout+timeout/2 {
+		t.Errorf("RenderBatch took %v with a %v timeout", elapsed, timeout)
+	}
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("Expression %d: expected an error", i)
+		}
+	}
+	if !errors.Is(errs[2], ErrTimeout) {
+		t.Errorf("Expression 2: got %v, want ErrTimeout", errs[2])
+	}
+
+	// Expressions that failed on their own are remembered; those that ran
+	// out of shared time are not
+	if res, ok := r.lookupRender(r.mathCacheKey("", "a", "white", false, 300)); !ok || res.err == nil {
+		t.Errorf("Expected remembered failure for 'a', got (%+v, %v)", res, ok)
+	}
+	if _, ok := r.lookupRender(r.mathCacheKey("", "d", "white", false, 300)); ok {
+		t.Errorf("Expected no remembered outcome for 'd'")
+	}
+}
@@ -0,0 +1,70 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ErrTimeout is reported, wrapped, when an external command runs for longer
+// than the render timeout
+var ErrTimeout = errors.New("timed out")
+
+// deadlineKey is the context key of a deadline set by withDeadline
+type deadlineKey struct{}
+
+// withDeadline returns a copy of ctx under which commands time out at
+// deadline if their own timeout would let them run longer. Unlike
+// context.WithDeadline, expiry is reported as ErrTimeout.
+func withDeadline(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, deadlineKey{}, deadline)
+}
+
+// runCommand runs cmd until it exits, ctx is done or timeout (if positive)
+// passes. On expiry the command's whole process group is killed, so that
+// nothing it started keeps running, and ErrTimeout or ctx's error returned.
+// A deadline set with withDeadline shortens the timeout.
+func runCommand(ctx context.Context, cmd *exec.Cmd, timeout time.Duration) error {
+	if deadline, ok := ctx.Value(deadlineKey{}).(time.Time); ok {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return fmt.Errorf("%w before it could start", ErrTimeout)
+		}
+		if timeout <= 0 || left < timeout {
+			timeout = left.Round(time.Millisecond)
+		}
+	}
+
+	runCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-runCtx.Done():
+		killProcessGroup(cmd)
+		<-done
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w after %v", ErrTimeout, timeout)
+	}
+}
@@ -0,0 +1,51 @@
+package latex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRunCommandTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	// The background sleep holds stdout open, so Wait only returns promptly
+	// if the whole process group is killed
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", "sleep 30 & sleep 30")
+	cmd.Stdout = &out
+	start := time.Now()
+	err := runCommand(context.Background(), cmd, 100*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("runCommand = %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("runCommand took %v to give up", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := runCommand(ctx, exec.Command("sh", "-c", "sleep 30"), 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("runCommand with cancelled context = %v, want context.Canceled", err)
+	}
+
+	if err := runCommand(context.Background(), exec.Command("sh", "-c", "exit 0"), time.Minute); err != nil {
+		t.Errorf("runCommand = %v, want success", err)
+	}
+
+	// A deadline shortens the timeout, and one that has passed stops the
+	// command from starting
+	start = time.Now()
+	err = runCommand(withDeadline(context.Background(), time.Now().Add(100*time.Millisecond)), exec.Command("sh", "-c", "sleep 30"), time.Minute)
+	if !errors.Is(err, ErrTimeout) || time.Since(start) > 10*time.Second {
+		t.Errorf("runCommand with deadline = %v after %v, want ErrTimeout", err, time.Since(start))
+	}
+	if err := runCommand(withDeadline(context.Background(), time.Now()), exec.Command("sh", "-c", "exit 0"), time.Minute); !errors.Is(err, ErrTimeout) {
+		t.Errorf("runCommand after deadline = %v, want ErrTimeout", err)
+	}
+}
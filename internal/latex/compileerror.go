@@ -0,0 +1,99 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CompileError is a TeX or rasteriser run that failed, summarised from its
+// log or output
+type CompileError struct {
+	Engine    string // name of the engine or rasteriser that failed
+	What      string // the input being compiled, for messages
+	Message   string // the first "!" error in the log, without the "! ", or a rasteriser's first error line
+	Line      int    // line of the TeX source the error was found on, 0 if unknown
+	Context   string // the source text TeX had read up to the error
+	Undefined string // the control sequence, if the error is an undefined one
+	Log       string // the full log, or the engine's output if there was no log
+	Dir       string // the temp dir the run used, removed unless temp dirs are kept
+	Err       error  // how the engine process failed
+}
+
+// Error returns a one-line diagnostic, e.g.
+// "pdflatex failed for '\fo x': Undefined control sequence \fo (line 9)"
+func (e *CompileError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s failed for %s: ", e.Engine, e.What)
+	switch {
+	case e.Message == "":
+		sb.WriteString(e.Err.Error())
+	case e.Undefined != "":
+		fmt.Fprintf(&sb, "Undefined control sequence %s", e.Undefined)
+	default:
+		sb.WriteString(strings.TrimSuffix(e.Message, "."))
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&sb, " (line %d)", e.Line)
+	}
+	return sb.String()
+}
+
+// Unwrap returns the process error
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// rasteriserError returns the error for a failed run of a rasteriser, with
+// the first line it wrote to stderr as the message
+func rasteriserError(name, what string, stdout, stderr []byte, err error) *CompileError {
+	e := &CompileError{
+		Engine: name,
+		What:   what,
+		Log:    fmt.Sprintf("%s STDOUT:\n%s\n%s STDERR:\n%s", name, stdout, name, stderr),
+		Err:    err,
+	}
+	for _, line := range strings.Split(string(stderr), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			e.Message = line
+			break
+		}
+	}
+	return e
+}
+
+// logErrorLine matches TeX's "l.<line> <source read so far>" error context
+var logErrorLine = regexp.MustCompile(`^l\.(\d+) (.*)$`)
+
+// trailingControlSequence matches the control sequence at the end of a line
+var trailingControlSequence = regexp.MustCompile(`(\\(?:[A-Za-z@]+|.))\s*$`)
+
+// parseLog fills in the error details from the first error in log
+func (e *CompileError) parseLog(log string) {
+	scanner := bufio.NewScanner(strings.NewReader(log))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if e.Message == "" {
+			if strings.HasPrefix(line, "! ") {
+				e.Message = strings.TrimSpace(line[2:])
+			}
+			continue
+		}
+
+		// The error is followed by help text and then its position
+		if m := logErrorLine.FindStringSubmatch(line); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Context = m[2]
+			if e.Message == "Undefined control sequence." {
+				if cs := trailingControlSequence.FindStringSubmatch(m[2]); cs != nil {
+					e.Undefined = cs[1]
+				}
+			}
+			return
+		}
+	}
+}
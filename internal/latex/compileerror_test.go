@@ -0,0 +1,102 @@
+package latex
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCompileErrorParseLog(t *testing.T) {
+	log := `This is pdfTeX, Version 3.141592653-2.6-1.40.25 (TeX Live 2023) (preloaded format=pdflatex 2023.5.1)  1 JAN 2024 12:00
+entering extended mode
+(/tmp/dml123/eq.tex
+LaTeX2e <2022-11-01> patch level 1
+! Undefined control sequence.
+<recently read> \fo 
+                    
+l.9 \begin{dmlpage}\color{white}$\fo
+                                     x$\end{dmlpage}
+The control sequence at the end of the top line
+of your error message was never \def'ed.
+
+! Missing $ inserted.
+l.12 ...
+`
+	e := &CompileError{Engine: "pdflatex", What: `'\fo x'`, Err: errors.New("exit status 1")}
+	e.parseLog(log)
+
+	if e.Message != "Undefined control sequence." || e.Line != 9 || e.Undefined != `\fo` {
+		t.Errorf("parseLog gave message %q, line %d, undefined %q", e.Message, e.Line, e.Undefined)
+	}
+	want := `pdflatex failed for '\fo x': Undefined control sequence \fo (line 9)`
+	if got := e.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	e = &CompileError{Engine: "pdflatex", What: "full document", Err: errors.New("exit status 1")}
+	e.parseLog("! LaTeX Error: Environment foo undefined.\n\nl.4 \\begin{foo}\n")
+	want = "pdflatex failed for full document: LaTeX Error: Environment foo undefined (line 4)"
+	if got := e.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	// Without an error in the log, the process error is reported
+	e = &CompileError{Engine: "xelatex", What: "'x'", Err: errors.New("exit status 1")}
+	e.parseLog("no errors here\n")
+	if got := e.Error(); got != "xelatex failed for 'x': exit status 1" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestRasteriserError(t *testing.T) {
+	e := rasteriserError("pdftoppm", "PDF '/tmp/x/eq.pdf'", []byte(""),
+		[]byte("\nSyntax Error: Couldn't find trailer dictionary\nSyntax Error: Couldn't read xref table\n"),
+		errors.New("exit status 1"))
+	want := "pdftoppm failed for PDF '/tmp/x/eq.pdf': Syntax Error: Couldn't find trailer dictionary"
+	if got := e.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !strings.Contains(e.Log, "Couldn't read xref table") {
+		t.Errorf("Log = %q, want the full output", e.Log)
+	}
+
+	// Without any output, the process error is reported
+	e = rasteriserError("dvipng", "DVI 'eq.dvi'", nil, nil, errors.New("exit status 1"))
+	if got := e.Error(); got != "dvipng failed for DVI 'eq.dvi': exit status 1" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestRunEngineCompileError(t *testing.T) {
+	// A stand-in engine that fails like TeX does, leaving a log behind
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	dir := t.TempDir()
+	r := &Renderer{engine: scriptEngine{`printf '! Emergency stop.\nl.1 x\n' > eq.log; exit 1`}, unsafe: true}
+
+	err := r.runEngine(context.Background(), dir, dir+"/eq.tex", false, "", "'x'")
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Fatalf("runEngine = %v, want *CompileError", err)
+	}
+	if compileErr.Message != "Emergency stop." || compileErr.Line != 1 {
+		t.Errorf("CompileError = %+v", compileErr)
+	}
+}
+
+// scriptEngine runs a shell script in the output directory in place of TeX
+type scriptEngine struct{ script string }
+
+func (e scriptEngine) Name() string    { return "script" }
+func (e scriptEngine) Available() bool { return true }
+func (e scriptEngine) Unicode() bool   { return false }
+func (e scriptEngine) Command(dir, texFile, format string, safe bool) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", e.script)
+	cmd.Dir = dir
+	return cmd
+}
+func (e scriptEngine) DVICommand(dir, texFile, format string, safe bool) *exec.Cmd { return nil }
+func (e scriptEngine) DumpCommand(dir, iniFile string, dvi bool) *exec.Cmd         { return nil }
@@ -0,0 +1,122 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rasterisers turn compiled LaTeX into PNG images
+const (
+	RasteriserAuto   = "auto"   // dvipng when available, otherwise pdf
+	RasteriserPDF    = "pdf"    // compile to PDF and rasterise it with pdftoppm or mutool
+	RasteriserDVIPNG = "dvipng" // compile to DVI and rasterise it with dvipng
+)
+
+// dvipngDepth matches the baseline depth that dvipng --depth reports per page
+var dvipngDepth = regexp.MustCompile(`depth=(-?\d+)`)
+
+// resolveRasteriser validates a rasteriser name for engine, turning
+// RasteriserAuto into dvipng whenever the engine and dvipng allow it
+func resolveRasteriser(name string, engine Engine) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case RasteriserPDF:
+		return RasteriserPDF, nil
+	case RasteriserDVIPNG:
+		if err := dvipngUsable(engine); err != nil {
+			return "", err
+		}
+		return RasteriserDVIPNG, nil
+	case "", RasteriserAuto:
+		err := dvipngUsable(engine)
+		if err == nil {
+			return RasteriserDVIPNG, nil
+		}
+		if isDebug {
+			fmt.Fprintf(os.Stderr, "DEBUG: Not using dvipng: %v\n", err)
+		}
+		return RasteriserPDF, nil
+	default:
+		return "", fmt.Errorf("unknown rasteriser '%s' (choose from %s, %s, %s)",
+			name, RasteriserAuto, RasteriserPDF, RasteriserDVIPNG)
+	}
+}
+
+// dvipngUsable reports why the dvipng path can't be used with engine
+func dvipngUsable(engine Engine) error {
+	cmd := engine.DVICommand("", "", "", false)
+	if cmd == nil {
+		return fmt.Errorf("engine '%s' does not produce DVI for dvipng", engine.Name())
+	}
+	if _, err := exec.LookPath(cmd.Args[0]); err != nil {
+		return fmt.Errorf("'%s' not found on PATH", cmd.Args[0])
+	}
+	if _, err := exec.LookPath("dvipng"); err != nil {
+		return fmt.Errorf("'dvipng' not found on PATH")
+	}
+	return nil
+}
+
+// rasteriseDVI converts every page of dir/eq.dvi to a PNG with dvipng.
+// dvipng renders straight onto a transparent background with anti-aliased
+// alpha, crops each page to its ink and reports where the baseline falls.
+func (r *Renderer) rasteriseDVI(ctx context.Context, dir string, dpi int, display []bool) ([]*Image, error) {
+	dviFile := dir + "/eq.dvi"
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("dvipng",
+		"-D", strconv.Itoa(dpi),
+		"-T", "tight",
+		"-bg", "Transparent",
+		"--truecolor",
+		"--depth",
+		"-o", dir+"/eq%d.png",
+		dviFile)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := runCommand(ctx, cmd, r.timeout); err != nil {
+		if errors.Is(err, ErrTimeout) {
+			return nil, fmt.Errorf("dvipng %w for DVI '%s'", err, dviFile)
+		}
+		return nil, rasteriserError("dvipng", fmt.Sprintf("DVI '%s'", dviFile), stdout.Bytes(), stderr.Bytes(), err)
+	}
+
+	depths := dvipngDepth.FindAllSubmatch(stdout.Bytes(), -1)
+	imgs := make([]*Image, len(display))
+	for j, isDisplay := range display {
+		pngFile := fmt.Sprintf("%s/eq%d.png", dir, j+1)
+		imgData, err := ioutil.ReadFile(pngFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PNG file '%s': %v", pngFile, err)
+		}
+
+		img, err := newImage(imgData, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isDisplay && j < len(depths) {
+			img.Depth, _ = strconv.Atoi(string(depths[j][1]))
+			if img.Depth < 0 || img.Depth > img.Height {
+				img.Depth = -1
+			}
+		}
+
+		if isDebug {
+			fmt.Fprintf(os.Stderr, "DEBUG: dvipng produced %dx%d px image, baseline %dpx above bottom\n",
+				img.Width, img.Height, img.Depth)
+		}
+		imgs[j] = img
+	}
+
+	return imgs, nil
+}
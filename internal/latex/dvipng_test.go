@@ -0,0 +1,37 @@
+package latex
+
+import (
+	"testing"
+)
+
+func TestResolveRasteriser(t *testing.T) {
+	pdflatex := Engines[0]
+
+	if _, err := resolveRasteriser("povray", pdflatex); err == nil {
+		t.Errorf("Expected error for unknown rasteriser")
+	}
+
+	if got, err := resolveRasteriser("pdf", pdflatex); err != nil || got != RasteriserPDF {
+		t.Errorf("resolveRasteriser(pdf) = %q, %v", got, err)
+	}
+
+	// Without dvipng on PATH, auto falls back to pdf and dvipng is refused
+	t.Setenv("PATH", t.TempDir())
+	if got, err := resolveRasteriser("auto", pdflatex); err != nil || got != RasteriserPDF {
+		t.Errorf("resolveRasteriser(auto) = %q, %v, want pdf", got, err)
+	}
+	if _, err := resolveRasteriser("dvipng", pdflatex); err == nil {
+		t.Errorf("Expected error selecting dvipng with empty PATH")
+	}
+	if _, err := NewRenderer(Options{Rasteriser: RasteriserDVIPNG}); err == nil {
+		t.Errorf("Expected NewRenderer to refuse dvipng with empty PATH")
+	}
+}
+
+func TestDVIPNGDepth(t *testing.T) {
+	out := "This is dvipng 1.15 Copyright 2002-2015 Jan-Ake Larsson\n[1 depth=7] \n"
+	m := dvipngDepth.FindStringSubmatch(out)
+	if m == nil || m[1] != "7" {
+		t.Errorf("dvipngDepth match = %v, want depth 7", m)
+	}
+}
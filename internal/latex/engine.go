@@ -0,0 +1,166 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Engine is a TeX engine that compiles a LaTeX source file to PDF
+type Engine interface {
+	// Name is the name used to select the engine with --engine
+	Name() string
+	// Available reports whether the engine's executable is on PATH
+	Available() bool
+	// Unicode reports whether the engine reads UTF-8 natively and loads
+	// OpenType fonts, which selects the fontspec-based template preamble
+	Unicode() bool
+	// Command returns the command that compiles texFile into dir. The PDF
+	// and log are written next to each other using the source file's name.
+	// A non-empty format names a precompiled format to start from, and safe
+	// turns off shell escape and anything else untrusted input could abuse.
+	Command(dir, texFile, format string, safe bool) *exec.Cmd
+	// DVICommand is like Command but produces DVI for dvipng, or returns nil
+	// if the engine cannot produce DVI that dvipng understands
+	DVICommand(dir, texFile, format string, safe bool) *exec.Cmd
+	// DumpCommand returns the command that runs the preamble in iniFile and
+	// dumps it as a format into dir, for Command or (if dvi) DVICommand.
+	// It returns nil if the engine cannot preload formats.
+	DumpCommand(dir, iniFile string, dvi bool) *exec.Cmd
+}
+
+// texEngine is one of the engines shipped with TeX distributions, which all
+// share pdflatex's command line
+type texEngine struct {
+	name    string
+	dvi     string // the DVI-producing variant, if dvipng can read its output
+	unicode bool
+	safer   bool // accepts --safer, which strips the file and process functions from Lua
+}
+
+func (e texEngine) Name() string { return e.name }
+
+func (e texEngine) Available() bool {
+	_, err := exec.LookPath(e.name)
+	return err == nil
+}
+
+func (e texEngine) Unicode() bool { return e.unicode }
+
+func (e texEngine) Command(dir, texFile, format string, safe bool) *exec.Cmd {
+	return exec.Command(e.name, e.args(dir, texFile, format, safe)...)
+}
+
+func (e texEngine) DVICommand(dir, texFile, format string, safe bool) *exec.Cmd {
+	if e.dvi == "" {
+		return nil
+	}
+	return exec.Command(e.dvi, e.args(dir, texFile, format, safe)...)
+}
+
+func (e texEngine) DumpCommand(dir, iniFile string, dvi bool) *exec.Cmd {
+	// fontspec loads OpenType fonts at run time, which a format can't hold
+	if e.unicode {
+		return nil
+	}
+	bin := e.name
+	if dvi {
+		bin = e.dvi
+	}
+	// "&bin" loads the engine's own LaTeX format before reading the preamble
+	return exec.Command(bin, "-ini", "-interaction=nonstopmode", "-output-directory", dir, "&"+bin, iniFile)
+}
+
+// args is the command line shared by the TeX distribution engines
+func (e texEngine) args(dir, texFile, format string, safe bool) []string {
+	args := []string{"-interaction=nonstopmode", "-output-directory", dir}
+	if safe {
+		args = append(args, "-no-shell-escape")
+		if e.safer {
+			args = append(args, "--safer")
+		}
+	}
+	if format != "" {
+		args = append(args, "-fmt="+format)
+	}
+	return append(args, texFile)
+}
+
+// tectonicEngine is the self-contained XeTeX-based Tectonic engine, which
+// fetches packages on demand and needs no TeX distribution
+type tectonicEngine struct{}
+
+func (tectonicEngine) Name() string { return "tectonic" }
+
+func (tectonicEngine) Available() bool {
+	_, err := exec.LookPath("tectonic")
+	return err == nil
+}
+
+func (tectonicEngine) Unicode() bool { return true }
+
+func (tectonicEngine) Command(dir, texFile, format string, safe bool) *exec.Cmd {
+	// Logs are kept because inline math reads its box metrics from them
+	args := []string{"--keep-logs", "--outdir", dir}
+	if safe {
+		args = append(args, "--untrusted")
+	}
+	return exec.Command("tectonic", append(args, texFile)...)
+}
+
+// Tectonic's XDV output uses native fonts that dvipng cannot rasterise
+func (tectonicEngine) DVICommand(dir, texFile, format string, safe bool) *exec.Cmd { return nil }
+
+// Tectonic manages its own format cache
+func (tectonicEngine) DumpCommand(dir, iniFile string, dvi bool) *exec.Cmd { return nil }
+
+// Engines lists the supported engines in order of preference for auto-detection
+var Engines = []Engine{
+	texEngine{name: "pdflatex", dvi: "latex"},
+	texEngine{name: "lualatex", unicode: true, safer: true},
+	texEngine{name: "xelatex", unicode: true},
+	tectonicEngine{},
+}
+
+// EngineNames returns the names of all supported engines
+func EngineNames() []string {
+	names := make([]string, len(Engines))
+	for i, e := range Engines {
+		names[i] = e.Name()
+	}
+	return names
+}
+
+// LookupEngine returns the engine with the given name, which must be on
+// PATH. The special name "auto" (or an empty name) picks the first engine
+// found on PATH.
+func LookupEngine(name string) (Engine, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == "auto" {
+		return DetectEngine()
+	}
+	for _, e := range Engines {
+		if e.Name() == name {
+			if !e.Available() {
+				return nil, fmt.Errorf("engine '%s' not found on PATH", name)
+			}
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown engine '%s' (choose from auto, %s)", name, strings.Join(EngineNames(), ", "))
+}
+
+// DetectEngine returns the first supported engine whose executable is on PATH
+func DetectEngine() (Engine, error) {
+	for _, e := range Engines {
+		if e.Available() {
+			if isDebug {
+				fmt.Fprintf(os.Stderr, "DEBUG: Auto-detected TeX engine: %s\n", e.Name())
+			}
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("no TeX engine found on PATH (looked for %s)", strings.Join(EngineNames(), ", "))
+}
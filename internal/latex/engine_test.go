@@ -0,0 +1,140 @@
+package latex
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookupEngine(t *testing.T) {
+	if _, err := LookupEngine("troff"); err == nil {
+		t.Errorf("Expected error for unknown engine")
+	}
+
+	// Engines that are not installed are rejected with a PATH error
+	t.Setenv("PATH", t.TempDir())
+	for _, name := range EngineNames() {
+		_, err := LookupEngine(name)
+		if err == nil || !strings.Contains(err.Error(), "not found on PATH") {
+			t.Errorf("LookupEngine(%q) with empty PATH: got error %v, want not found on PATH", name, err)
+		}
+	}
+	if _, err := LookupEngine("auto"); err == nil {
+		t.Errorf("Expected auto-detection to fail with empty PATH")
+	}
+}
+
+func TestEngineCommand(t *testing.T) {
+	tests := []struct {
+		engine Engine
+		want   string
+	}{
+		{Engines[0], "pdflatex -interaction=nonstopmode -output-directory /tmp/x /tmp/x/eq.tex"},
+		{Engines[1], "lualatex -interaction=nonstopmode -output-directory /tmp/x /tmp/x/eq.tex"},
+		{Engines[2], "xelatex -interaction=nonstopmode -output-directory /tmp/x /tmp/x/eq.tex"},
+		{Engines[3], "tectonic --keep-logs --outdir /tmp/x /tmp/x/eq.tex"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.engine.Name(), func(t *testing.T) {
+			got := strings.Join(test.engine.Command("/tmp/x", "/tmp/x/eq.tex", "", false).Args, " ")
+			if got != test.want {
+				t.Errorf("Command = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEngineSafeCommand(t *testing.T) {
+	tests := []struct {
+		engine Engine
+		want   string
+	}{
+		{Engines[0], "pdflatex -interaction=nonstopmode -output-directory /tmp/x -no-shell-escape /tmp/x/eq.tex"},
+		{Engines[1], "lualatex -interaction=nonstopmode -output-directory /tmp/x -no-shell-escape --safer /tmp/x/eq.tex"},
+		{Engines[3], "tectonic --keep-logs --outdir /tmp/x --untrusted /tmp/x/eq.tex"},
+	}
+
+	for _, test := range tests {
+		got := strings.Join(test.engine.Command("/tmp/x", "/tmp/x/eq.tex", "", true).Args, " ")
+		if got != test.want {
+			t.Errorf("%s safe Command = %q, want %q", test.engine.Name(), got, test.want)
+		}
+	}
+}
+
+func TestFontPreamble(t *testing.T) {
+	pdflatex := Engines[0]
+	if got := fontPreamble(pdflatex, false); got != "" {
+		t.Errorf("pdflatex math preamble = %q, want empty", got)
+	}
+	if got := fontPreamble(pdflatex, true); !strings.Contains(got, "fontenc") {
+		t.Errorf("pdflatex document preamble = %q, want fontenc", got)
+	}
+	for _, e := range Engines[1:] {
+		if got := fontPreamble(e, false); !strings.Contains(got, "fontspec") {
+			t.Errorf("%s math preamble = %q, want fontspec", e.Name(), got)
+		}
+	}
+}
+
+func TestUsePackage(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"physics", `\usepackage{physics}`},
+		{"siunitx[per-mode=symbol]", `\usepackage[per-mode=symbol]{siunitx}`},
+		{"mhchem[version=4]", `\usepackage[version=4]{mhchem}`},
+	}
+	for _, test := range tests {
+		got, err := UsePackage(test.spec)
+		if err != nil || got != test.want {
+			t.Errorf("UsePackage(%q) = %q, %v, want %q", test.spec, got, err, test.want)
+		}
+	}
+
+	for _, spec := range []string{"", "[x]", "a}b", "x[y", `x[\input{z}]`} {
+		if _, err := UsePackage(spec); err == nil {
+			t.Errorf("UsePackage(%q): expected error", spec)
+		}
+	}
+}
+
+func TestUserPreamble(t *testing.T) {
+	plain, _ := NewRenderer(Options{Rasteriser: RasteriserPDF})
+	physics, _ := NewRenderer(Options{Rasteriser: RasteriserPDF, Preamble: `\usepackage{physics}`})
+
+	for _, fullDoc := range []bool{false, true} {
+		if got := physics.docPreamble(fullDoc); !strings.HasSuffix(got, `\usepackage{physics}`) {
+			t.Errorf("docPreamble(%v) = %q, want the user preamble last", fullDoc, got)
+		}
+	}
+	if got := plain.docPreamble(false); got != "" {
+		t.Errorf("docPreamble without a user preamble = %q, want empty", got)
+	}
+
+	// Renders with different preambles must never share cache entries
+	if plain.mathCacheKey("", `\dv{f}{x}`, "white", false, 300) == physics.mathCacheKey("", `\dv{f}{x}`, "white", false, 300) {
+		t.Errorf("Expected the preamble to be part of the cache key")
+	}
+}
+
+func TestEngineFormat(t *testing.T) {
+	pdflatex := Engines[0]
+	got := strings.Join(pdflatex.Command("/tmp/x", "/tmp/x/eq.tex", "/tmp/f/dml", false).Args, " ")
+	if want := "pdflatex -interaction=nonstopmode -output-directory /tmp/x -fmt=/tmp/f/dml /tmp/x/eq.tex"; got != want {
+		t.Errorf("Command with format = %q, want %q", got, want)
+	}
+
+	got = strings.Join(pdflatex.DumpCommand("/tmp/f", "/tmp/f/dml.tex", true).Args, " ")
+	if want := "latex -ini -interaction=nonstopmode -output-directory /tmp/f &latex /tmp/f/dml.tex"; got != want {
+		t.Errorf("DumpCommand = %q, want %q", got, want)
+	}
+
+	// Formats can't hold the OpenType fonts of the Unicode engines
+	for _, e := range Engines[1:] {
+		if e.DumpCommand("/tmp/f", "/tmp/f/dml.tex", false) != nil {
+			t.Errorf("%s: expected no DumpCommand", e.Name())
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Equations numbers display math in input order and resolves \ref and
+// \eqref to the numbers, like LaTeX's equation counter and labels. Each
+// expression is rendered without its number, which the caller prints
+// itself. It is not safe for concurrent use; call Number on display math
+// and the Resolve methods on everything else in input order.
+type Equations struct {
+	count  int
+	labels map[string]string // label -> number or tag
+}
+
+// mathEnvironment matches a display math environment, capturing its name
+// and whether it is starred
+var mathEnvironment = regexp.MustCompile(`^\\begin\{(equation|align|alignat|gather|multline|flalign)(\*?)\}`)
+
+var (
+	labelCommand = regexp.MustCompile(`\\label\{([^{}\\]*)\}`)
+	tagCommand   = regexp.MustCompile(`\\tag(\*?)\{([^{}]*)\}`)
+	noTagCommand = regexp.MustCompile(`\\(?:notag|nonumber)\b`)
+	refCommand   = regexp.MustCompile(`\\(eqref|ref)\{([^{}\\]*)\}`)
+)
+
+// rowEnvironments are the numbered environments that number each of their
+// rows, rather than the block as a whole
+var rowEnvironments = map[string]bool{"align": true, "alignat": true, "gather": true, "flalign": true}
+
+// Number assigns the next equation number to a display math expression if
+// it is numbered: if it uses a numbered environment, or no environment but
+// has a \label, and it has no \notag or \nonumber. \tag{x} gives it the tag
+// x without using a number. Labels are recorded for the Resolve methods. It
+// returns the expression with labels and tags removed and its environment
+// starred, ready to render, and the tag to print beside it, e.g. "(3)", or
+// "" if it is unnumbered.
+//
+// An align, alignat, gather or flalign with several rows numbers each row
+// on its own, as LaTeX does. The tags can't all be printed beside the
+// image, so each row's tag is set on the row with \tag* and "" is returned.
+func (q *Equations) Number(latex string) (string, string) {
+	latex = strings.TrimSpace(latex)
+	env := mathEnvironment.FindStringSubmatch(latex)
+	if env == nil || env[2] != "" {
+		number, tag := q.number(latex, env == nil && labelCommand.MatchString(latex))
+		q.label(latex, number)
+		return strings.TrimSpace(stripNumbering(latex)), tag
+	}
+
+	end := strings.LastIndex(latex, `\end{`+env[1]+`}`)
+	if end < 0 {
+		end = len(latex)
+	}
+	if rows, seps := splitRows(latex[len(env[0]):end]); rowEnvironments[env[1]] && len(rows) > 1 {
+		var sb strings.Builder
+		sb.WriteString(latex[:len(env[0])])
+		for i, row := range rows {
+			if i > 0 {
+				sb.WriteString(seps[i-1])
+			}
+			// A \\ after the last row doesn't start another
+			if i == len(rows)-1 && strings.TrimSpace(row) == "" {
+				sb.WriteString(row)
+				break
+			}
+			number, tag := q.number(row, true)
+			q.label(row, number)
+			sb.WriteString(stripNumbering(row))
+			if tag != "" {
+				sb.WriteString(`\tag*{` + tag + `}`)
+			}
+		}
+		sb.WriteString(latex[end:])
+		return starEnvironment(sb.String(), env[1]), ""
+	}
+
+	number, tag := q.number(latex, true)
+	q.label(latex, number)
+	return starEnvironment(strings.TrimSpace(stripNumbering(latex)), env[1]), tag
+}
+
+// number returns the number and tag of an equation or row s: its \tag, or
+// the next number if it is numbered and has no \notag or \nonumber, or ""
+// for both
+func (q *Equations) number(s string, numbered bool) (string, string) {
+	if m := tagCommand.FindStringSubmatch(s); m != nil {
+		number := strings.TrimSpace(m[2])
+		if m[1] == "*" {
+			return number, number
+		}
+		return number, "(" + number + ")"
+	}
+	if !numbered || noTagCommand.MatchString(s) {
+		return "", ""
+	}
+	q.count++
+	number := strconv.Itoa(q.count)
+	return number, "(" + number + ")"
+}
+
+// label records number for every \label in s
+func (q *Equations) label(s, number string) {
+	labels := labelCommand.FindAllStringSubmatch(s, -1)
+	if len(labels) > 0 && q.labels == nil {
+		q.labels = make(map[string]string)
+	}
+	for _, m := range labels {
+		q.labels[m[1]] = number
+	}
+}
+
+// stripNumbering removes labels, tags and \notag from s
+func stripNumbering(s string) string {
+	s = labelCommand.ReplaceAllString(s, "")
+	s = tagCommand.ReplaceAllString(s, "")
+	return noTagCommand.ReplaceAllString(s, "")
+}
+
+// starEnvironment stars the environment name that latex begins and ends
+func starEnvironment(latex, name string) string {
+	latex = strings.Replace(latex, `\begin{`+name+`}`, `\begin{`+name+`*}`, 1)
+	if i := strings.LastIndex(latex, `\end{`+name+`}`); i >= 0 {
+		latex = latex[:i] + `\end{` + name + `*}` + latex[i+len(`\end{`+name+`}`):]
+	}
+	return latex
+}
+
+// splitRows splits the body of an environment at the \\ that end its rows,
+// ignoring those inside braces or nested environments such as cases. It
+// returns the rows and the separators between them.
+func splitRows(body string) (rows, seps []string) {
+	depth := 0
+	start := 0
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '\\':
+			switch {
+			case strings.HasPrefix(body[i:], `\\`) && depth == 0:
+				rows = append(rows, body[start:i])
+				seps = append(seps, `\\`)
+				start = i + 2
+			case strings.HasPrefix(body[i:], `\begin{`):
+				depth++
+			case strings.HasPrefix(body[i:], `\end{`):
+				depth--
+			}
+			i++
+		}
+	}
+	return append(rows, body[start:]), seps
+}
+
+// ResolveText replaces \ref and \eqref in plain text with the numbers of
+// the equations labelled so far. Unknown labels become ?? as in LaTeX.
+func (q *Equations) ResolveText(text string) string {
+	return q.resolve(text, false)
+}
+
+// ResolveMath is ResolveText for LaTeX math, where the numbers are set as
+// upright text
+func (q *Equations) ResolveMath(latex string) string {
+	return q.resolve(latex, true)
+}
+
+// resolve replaces the references in s, as upright text if inMath is set
+func (q *Equations) resolve(s string, inMath bool) string {
+	return refCommand.ReplaceAllStringFunc(s, func(match string) string {
+		m := refCommand.FindStringSubmatch(match)
+		number, ok := q.labels[m[2]]
+		if !ok || number == "" {
+			number = "??"
+		}
+		if m[1] == "eqref" {
+			number = "(" + number + ")"
+		}
+		if inMath {
+			return `\textup{` + number + `}`
+		}
+		return number
+	})
+}
+
+// isMathEnvironment reports whether latex is a single display math
+// environment, which must not be wrapped in \[...\]
+func isMathEnvironment(latex string) bool {
+	latex = strings.TrimSpace(latex)
+	m := mathEnvironment.FindStringSubmatch(latex)
+	return m != nil && strings.HasSuffix(latex, `\end{`+m[1]+m[2]+`}`)
+}
+
+// DisplayMathBlock returns the LaTeX for display math in a full document:
+// environments are kept as they are, math with a \label or \tag becomes a
+// numbered equation and anything else is unnumbered
+func DisplayMathBlock(content string) string {
+	switch {
+	case isMathEnvironment(content):
+		return strings.TrimSpace(content)
+	case labelCommand.MatchString(content) || tagCommand.MatchString(content):
+		return `\begin{equation}` + content + `\end{equation}`
+	default:
+		return `$$` + content + `$$`
+	}
+}
@@ -0,0 +1,35 @@
+package latex
+
+import (
+	"testing"
+)
+
+func TestEquationsNumberRows(t *testing.T) {
+	var q Equations
+	tests := []struct {
+		latex string
+		want  string
+		tag   string
+	}{
+		// Each row of a numbered align gets its own number, set on the row
+		{`\begin{align} a &= b \label{eq:a} \\ c &= d \notag \\ e &= f \label{eq:e} \\ \end{align}`,
+			`\begin{align*} a &= b  \tag*{(1)}\\ c &= d  \\ e &= f  \tag*{(2)}\\ \end{align*}`, ``},
+		// Rows inside nested environments and braces are not rows of the align
+		{`\begin{gather} f = \begin{cases} 1 \\ 0 \end{cases} \\ g \tag{G} \end{gather}`,
+			`\begin{gather*} f = \begin{cases} 1 \\ 0 \end{cases} \tag*{(3)}\\ g  \tag*{(G)}\end{gather*}`, ``},
+		// One row is numbered like an equation; multline is one equation
+		{`\begin{align} x &= y \label{eq:x} \end{align}`, `\begin{align*} x &= y  \end{align*}`, `(4)`},
+		{`\begin{multline} a \\ b \end{multline}`, `\begin{multline*} a \\ b \end{multline*}`, `(5)`},
+		{`\begin{align*} a \\ b \end{align*}`, `\begin{align*} a \\ b \end{align*}`, ``},
+	}
+	for _, test := range tests {
+		got, tag := q.Number(test.latex)
+		if got != test.want || tag != test.tag {
+			t.Errorf("Number(%q) = %q, %q, want %q, %q", test.latex, got, tag, test.want, test.tag)
+		}
+	}
+
+	if got, want := q.ResolveText(`\eqref{eq:a} \ref{eq:e} \ref{eq:x}`), `(1) 2 4`; got != want {
+		t.Errorf("ResolveText = %q, want %q", got, want)
+	}
+}
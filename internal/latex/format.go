@@ -0,0 +1,120 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"dml/internal/cache"
+)
+
+// mathFormat returns the precompiled format for the renderer's math
+// preamble, dumping it on first use. It returns "" when formats are
+// disabled or can't be built, in which case renders load the preamble.
+func (r *Renderer) mathFormat(ctx context.Context) string {
+	r.formatOnce.Do(func() {
+		if r.formatDir == "" {
+			return
+		}
+		format, err := r.loadFormat(ctx, fmt.Sprintf(mathPreamble, r.docPreamble(false)))
+		if err != nil {
+			if isDebug {
+				fmt.Fprintf(os.Stderr, "DEBUG: Not using a precompiled format: %v\n", err)
+				var compileErr *CompileError
+				if errors.As(err, &compileErr) {
+					fmt.Fprintf(os.Stderr, "DEBUG: %s log for %s:\n%s\n", compileErr.Engine, compileErr.What, compileErr.Log)
+				}
+			}
+			return
+		}
+		r.format = format
+	})
+	return r.format
+}
+
+// loadFormat returns the format in the renderer's format directory that
+// preloads preamble, dumping it first if it doesn't exist yet. The result
+// is the format's path without the .fmt extension, as -fmt expects.
+func (r *Renderer) loadFormat(ctx context.Context, preamble string) (string, error) {
+	dvi := r.rasteriser == RasteriserDVIPNG
+	probe := r.engine.DumpCommand("", "", dvi)
+	if probe == nil {
+		return "", fmt.Errorf("engine '%s' cannot preload formats", r.engine.Name())
+	}
+	key, err := formatKey(probe.Args[0], preamble)
+	if err != nil {
+		return "", err
+	}
+
+	name := "dml-" + key
+	format := filepath.Join(r.formatDir, name)
+	if _, err := os.Stat(format + ".fmt"); err == nil {
+		return format, nil
+	}
+
+	// Dump into a private directory and rename into place, so concurrent
+	// dml processes never load a partially written format
+	if err := os.MkdirAll(r.formatDir, 0755); err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir(r.formatDir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	iniFile := filepath.Join(dir, name+".tex")
+	if err := ioutil.WriteFile(iniFile, []byte(preamble+"\\dump\n"), 0644); err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := r.engine.DumpCommand(dir, iniFile, dvi)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Dumping format: %s\n", strings.Join(cmd.Args, " "))
+	}
+	if err := runCommand(ctx, cmd, r.timeout); err != nil {
+		if errors.Is(err, ErrTimeout) {
+			return "", fmt.Errorf("%s %w dumping format", cmd.Args[0], err)
+		}
+		// The format's log is removed with dir, but TeX echoes its errors to stdout
+		compileErr := &CompileError{
+			Engine: cmd.Args[0],
+			What:   "format dump",
+			Log:    fmt.Sprintf("LaTeX STDOUT:\n%s\nLaTeX STDERR:\n%s", stdout.String(), stderr.String()),
+			Err:    err,
+		}
+		compileErr.parseLog(compileErr.Log)
+		return "", compileErr
+	}
+
+	if err := os.Rename(filepath.Join(dir, name+".fmt"), format+".fmt"); err != nil {
+		return "", fmt.Errorf("%s appeared to succeed but did not dump a format: %v", cmd.Args[0], err)
+	}
+	return format, nil
+}
+
+// formatKey identifies a format by the preamble it preloads and the engine
+// executable that dumped it. A format only loads into the exact binary that
+// wrote it, so the executable's path and modification time are part of the
+// key and a TeX upgrade dumps a fresh one.
+func formatKey(bin, preamble string) (string, error) {
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return "", fmt.Errorf("'%s' not found on PATH", bin)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return cache.Key("format", path, fi.ModTime().UTC().String(), preamble), nil
+}
@@ -0,0 +1,45 @@
+package latex
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatKey(t *testing.T) {
+	// Any executable will do to stand in for the engine
+	bin, err := os.Executable()
+	if err != nil {
+		t.Skip("test executable not found")
+	}
+
+	a, err := formatKey(bin, `\usepackage{amsmath}`)
+	if err != nil {
+		t.Fatalf("formatKey: %v", err)
+	}
+	b, _ := formatKey(bin, `\usepackage{amsmath}`)
+	c, _ := formatKey(bin, `\usepackage{amsmath}\usepackage{tikz}`)
+	if a != b {
+		t.Errorf("Same preamble gave different keys")
+	}
+	if a == c {
+		t.Errorf("Different preambles gave the same key")
+	}
+
+	if _, err := formatKey(filepath.Join(t.TempDir(), "missing"), ""); err == nil {
+		t.Errorf("Expected error for missing engine")
+	}
+}
+
+func TestMathFormatUnavailable(t *testing.T) {
+	// Without an engine to dump it, rendering goes on without a format
+	t.Setenv("PATH", t.TempDir())
+	r, err := NewRenderer(Options{Rasteriser: RasteriserPDF, FormatDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+	if got := r.mathFormat(context.Background()); got != "" {
+		t.Errorf("mathFormat() = %q, want none", got)
+	}
+}
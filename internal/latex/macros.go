@@ -0,0 +1,246 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Macros collects the macro definitions met in a document's math, so that
+// expressions compiled on their own can use macros defined earlier on. It is
+// not safe for concurrent use; callers extract definitions in input order and
+// pass String() to later expressions as MathExpr.Macros.
+type Macros struct {
+	// Unsafe records definitions that safe mode would refuse to compile.
+	// Otherwise such a definition is left in its expression, which then
+	// fails on its own instead of every expression that comes after it.
+	Unsafe bool
+
+	names []string          // defined macros, ordered by their latest definition
+	defs  map[string]string // latest definition of each macro, as replayed
+}
+
+// definitionCommand matches the start of a definition that Macros records
+var definitionCommand = regexp.MustCompile(`\\(newcommand|renewcommand|def|DeclareMathOperator)\b`)
+
+// Extract records the definitions in latex and returns the rest of the
+// expression, trimmed, and whether there were any. An expression that only
+// defines macros leaves "". A definition that can't be parsed, or isn't
+// allowed in safe mode, is left in place for LaTeX or the renderer to report.
+func (m *Macros) Extract(latex string) (string, bool) {
+	var rest strings.Builder
+	found := false
+	pos := 0
+	for pos < len(latex) {
+		loc := definitionCommand.FindStringSubmatchIndex(latex[pos:])
+		if loc == nil {
+			break
+		}
+		start, cmdEnd := pos+loc[0], pos+loc[1]
+		name, def, end := parseDefinition(latex, cmdEnd, latex[pos+loc[2]:pos+loc[3]])
+		if end < 0 {
+			rest.WriteString(latex[pos:cmdEnd])
+			pos = cmdEnd
+			continue
+		}
+		if !m.Unsafe && checkSafe(def, MaxSafeLength) != nil {
+			rest.WriteString(latex[pos:end])
+			pos = end
+			continue
+		}
+		rest.WriteString(latex[pos:start])
+		m.define(name, def)
+		found = true
+		pos = end
+	}
+	if !found {
+		return latex, false
+	}
+	rest.WriteString(latex[pos:])
+	return strings.TrimSpace(rest.String()), true
+}
+
+// define records def as the latest definition of the macro name,
+// replacing any earlier one
+func (m *Macros) define(name, def string) {
+	if m.defs == nil {
+		m.defs = make(map[string]string)
+	}
+	if _, ok := m.defs[name]; ok {
+		for i, n := range m.names {
+			if n == name {
+				m.names = append(m.names[:i], m.names[i+1:]...)
+				break
+			}
+		}
+	}
+	m.names = append(m.names, name)
+	m.defs[name] = def
+}
+
+// String returns the latest definition of every macro recorded so far,
+// ready to be typeset before an expression
+func (m *Macros) String() string {
+	var sb strings.Builder
+	for _, name := range m.names {
+		sb.WriteString(m.defs[name])
+	}
+	return sb.String()
+}
+
+// parseDefinition parses the arguments of the definition command cmd, which
+// end at i in s. It returns the name of the macro defined, the definition
+// as it should be replayed and the index just past it, or -1 if the
+// arguments are malformed.
+func parseDefinition(s string, i int, cmd string) (string, string, int) {
+	args := i
+	switch cmd {
+	case "newcommand", "renewcommand":
+		i = skipStar(s, i)
+		nameStart := skipSpace(s, i)
+		if i = skipName(s, i, true); i < 0 {
+			return "", "", -1
+		}
+		name := strings.TrimSpace(strings.Trim(s[nameStart:i], "{}"))
+		i = skipOptional(s, skipSpace(s, i)) // number of arguments
+		i = skipOptional(s, skipSpace(s, i)) // default for the first
+		if i = skipGroup(s, skipSpace(s, i)); i < 0 {
+			return "", "", -1
+		}
+		// The macro may already be defined, by the preamble or an earlier
+		// definition, or not, whichever command the input used
+		return name, `\providecommand{` + name + `}{}\renewcommand` + s[args:i], i
+
+	case "def":
+		nameStart := skipSpace(s, i)
+		if i = skipName(s, i, false); i < 0 {
+			return "", "", -1
+		}
+		name := s[nameStart:i]
+		// The parameter text runs up to the body's opening brace
+		for i < len(s) && s[i] != '{' {
+			if s[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i = skipGroup(s, i); i < 0 {
+			return "", "", -1
+		}
+		return name, `\def` + s[args:i], i
+
+	case "DeclareMathOperator":
+		// \DeclareMathOperator only works in the preamble, so it is replayed
+		// as the robust command it defines
+		operator := `\operatorname`
+		if j := skipStar(s, i); j != i {
+			operator += "*"
+			i = j
+		}
+		nameStart := skipSpace(s, i)
+		if i = skipName(s, i, true); i < 0 {
+			return "", "", -1
+		}
+		name := strings.TrimSpace(strings.Trim(s[nameStart:i], "{}"))
+		textStart := skipSpace(s, i)
+		if i = skipGroup(s, textStart); i < 0 {
+			return "", "", -1
+		}
+		return name, `\DeclareRobustCommand{` + name + `}{` + operator + s[textStart:i] + `}`, i
+	}
+	return "", "", -1
+}
+
+// skipSpace returns the index of the first non-space at or after i
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// skipStar skips an optional * after spaces
+func skipStar(s string, i int) int {
+	if j := skipSpace(s, i); j < len(s) && s[j] == '*' {
+		return j + 1
+	}
+	return i
+}
+
+// skipName skips the control sequence being defined, which may be braced
+// when braced is true. It returns -1 if there isn't one.
+func skipName(s string, i int, braced bool) int {
+	i = skipSpace(s, i)
+	if braced && i < len(s) && s[i] == '{' {
+		i = skipName(s, i+1, false)
+		if i < 0 {
+			return -1
+		}
+		if i = skipSpace(s, i); i >= len(s) || s[i] != '}' {
+			return -1
+		}
+		return i + 1
+	}
+	if i+1 >= len(s) || s[i] != '\\' {
+		return -1
+	}
+	i++
+	if !isLetter(s[i]) {
+		return i + 1 // Control symbol
+	}
+	for i < len(s) && isLetter(s[i]) {
+		i++
+	}
+	return i
+}
+
+// skipOptional skips a bracketed optional argument at i, if there is one
+func skipOptional(s string, i int) int {
+	if i >= len(s) || s[i] != '[' {
+		return i
+	}
+	depth := 0
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ']':
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return i
+}
+
+// skipGroup skips the brace group starting at i, returning -1 if there is
+// no group there or it is unbalanced
+func skipGroup(s string, i int) int {
+	if i >= len(s) || s[i] != '{' {
+		return -1
+	}
+	depth := 0
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// isLetter reports whether c can be part of a control word
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
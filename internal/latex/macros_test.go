@@ -0,0 +1,151 @@
+package latex
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMacrosExtract(t *testing.T) {
+	tests := []struct {
+		latex string
+		rest  string
+		defs  string
+	}{
+		{` \vv + w `, ` \vv + w `, ``},
+		{`\newcommand{\vv}{\mathbf{v}}`, ``, `\providecommand{\vv}{}\renewcommand{\vv}{\mathbf{v}}`},
+		{`\newcommand\vv{\mathbf{v}} \vv`, `\vv`, `\providecommand{\vv}{}\renewcommand\vv{\mathbf{v}}`},
+		{`\renewcommand*{\abs}[1][x]{\left|#1\right|}`, ``, `\providecommand{\abs}{}\renewcommand*{\abs}[1][x]{\left|#1\right|}`},
+		{`\def\pair#1#2{(#1,#2)}\pair ab`, `\pair ab`, `\def\pair#1#2{(#1,#2)}`},
+		{`\DeclareMathOperator{\sgn}{sgn}`, ``, `\DeclareRobustCommand{\sgn}{\operatorname{sgn}}`},
+		{`\DeclareMathOperator*\argmax{arg\,max}`, ``, `\DeclareRobustCommand{\argmax}{\operatorname*{arg\,max}}`},
+		{`\newcommand{\a}{1} x \def\b{\{}`, `x`, `\providecommand{\a}{}\renewcommand{\a}{1}\def\b{\{}`},
+		// Malformed definitions and lookalikes are left alone
+		{`\newcommand{\vv}{\mathbf{v}`, `\newcommand{\vv}{\mathbf{v}`, ``},
+		{`\default + \defeq`, `\default + \defeq`, ``},
+	}
+
+	for _, test := range tests {
+		var m Macros
+		got, found := m.Extract(test.latex)
+		if got != test.rest || found != (test.defs != "") {
+			t.Errorf("Extract(%q) = %q, %v, want %q", test.latex, got, found, test.rest)
+		}
+		if got := m.String(); got != test.defs {
+			t.Errorf("Extract(%q) recorded %q, want %q", test.latex, got, test.defs)
+		}
+	}
+}
+
+func TestMacrosAccumulate(t *testing.T) {
+	var m Macros
+	m.Extract(`\newcommand{\vv}{\mathbf{v}}`)
+	m.Extract(`\def\ww{w}`)
+	if got, want := m.String(), `\providecommand{\vv}{}\renewcommand{\vv}{\mathbf{v}}\def\ww{w}`; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	// Defining a macro again, even with \newcommand as replies often do,
+	// replaces its earlier definition
+	m.Extract(`\newcommand{ \vv }{\vec{v}}`)
+	if got, want := m.String(), `\def\ww{w}\providecommand{\vv}{}\renewcommand{ \vv }{\vec{v}}`; got != want {
+		t.Errorf("String() after redefinition = %q, want %q", got, want)
+	}
+	m.Extract(`\def\ww#1{#1}`)
+	if got := m.String(); strings.Count(got, `\ww`) != 1 || !strings.HasSuffix(got, `\def\ww#1{#1}`) {
+		t.Errorf("String() after \\def redefinition = %q, want one definition of \\ww", got)
+	}
+}
+
+func TestRenderBatchMacros(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	r, err := NewRenderer(Options{Rasteriser: RasteriserPDF})
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+
+	// The same expression under different definitions is a different render
+	if r.mathCacheKey("", `\vv`, "white", false, 300) == r.mathCacheKey(`\def\vv{v}`, `\vv`, "white", false, 300) {
+		t.Errorf("Expected macros to be part of the cache key")
+	}
+
+	// Definitions are checked in safe mode like the expression itself
+	_, errs := r.RenderBatch(context.Background(), []MathExpr{{LaTeX: `\x`, Macros: `\def\x{\input{/etc/passwd}}`}}, "white", 300)
+	if errs[0] == nil || !strings.Contains(errs[0].Error(), `\input is not allowed`) {
+		t.Errorf("Expected safe mode to reject the macros, got %v", errs[0])
+	}
+}
+
+func TestMacrosSafeMode(t *testing.T) {
+	var m Macros
+	leak := `\newcommand{\leak}{\input{/etc/passwd}} \leak`
+	if got, found := m.Extract(leak); got != leak || found {
+		t.Errorf("Extract(%q) = %q, %v, want it left in place", leak, got, found)
+	}
+	if got, _ := m.Extract(`\newcommand{\vv}{\mathbf{v}} \vv`); got != `\vv` {
+		t.Errorf("Extract after a rejected definition = %q, want %q", got, `\vv`)
+	}
+	if got, want := m.String(), `\providecommand{\vv}{}\renewcommand{\vv}{\mathbf{v}}`; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	unsafe := Macros{Unsafe: true}
+	if got, _ := unsafe.Extract(leak); got != `\leak` {
+		t.Errorf("Unsafe Extract(%q) = %q, want the definition recorded", leak, got)
+	}
+}
+
+func TestRenderBatchAfterUnsafeMacro(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	// A stand-in pdftoppm that "rasterises" a blank page pair
+	bin := t.TempDir()
+	for name, c := range map[string]color.Color{"black.png": color.Black, "white.png": color.White} {
+		img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+		for i := 0; i < 16; i++ {
+			img.Set(i%4, i/4, c)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("png.Encode failed: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(bin, name), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := "#!/bin/sh\nfor p; do :; done\ncp " + bin + "/black.png \"$p-1.png\" && cp " + bin + "/white.png \"$p-2.png\"\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "pdftoppm"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+":/bin:/usr/bin")
+
+	// The engine only succeeds if the rejected definition was kept out
+	engine := scriptEngine{`grep -q leak eq.tex && exit 1; touch eq.pdf`}
+	r, err := NewRenderer(Options{Engine: engine, Rasteriser: RasteriserPDF})
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+
+	var m Macros
+	bad, _ := m.Extract(`\newcommand{\leak}{\input{/etc/passwd}} \leak`)
+	good, _ := m.Extract(`\newcommand{\vv}{\mathbf{v}} \vv`)
+	imgs, errs := r.RenderBatch(context.Background(), []MathExpr{
+		{LaTeX: bad, Macros: m.String()},
+		{LaTeX: good, Macros: m.String()},
+	}, "white", 300)
+	if errs[0] == nil {
+		t.Errorf("Expected the expression with the rejected definition to fail")
+	}
+	if errs[1] != nil || imgs[1] == nil {
+		t.Errorf("Expected the later expression to render, got %v", errs[1])
+	}
+}
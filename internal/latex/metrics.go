@@ -0,0 +1,81 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// mathBorderPt is the standalone border around each expression; it must
+// match the border option in TexTemplate
+const mathBorderPt = 2.0
+
+// metricsMarker prefixes the box dimensions written to the log by inline renders
+const metricsMarker = "DMLMETRICS"
+
+var metricsLine = regexp.MustCompile(metricsMarker + ` ([0-9]+) (-?[0-9.]+)pt (-?[0-9.]+)pt`)
+
+// boxMetrics is the height and depth of a typeset box, in pt
+type boxMetrics struct {
+	height float64
+	depth  float64
+}
+
+// Image is a rendered expression together with its typesetting metrics
+type Image struct {
+	PNG    []byte
+	Width  int // in pixels
+	Height int // in pixels
+	Depth  int // pixels from the baseline to the bottom edge, or -1 if unknown
+}
+
+// newImage wraps PNG data, reading its dimensions from the header
+func newImage(data []byte, depth int) (*Image, error) {
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("rendered PNG is invalid: %v", err)
+	}
+	return &Image{PNG: data, Width: cfg.Width, Height: cfg.Height, Depth: depth}, nil
+}
+
+// measuredInlineMath wraps inline math so that the height and depth of its
+// box are written to the log, tagged with id, before it is typeset
+func measuredInlineMath(id int, latex string) string {
+	return fmt.Sprintf(`\sbox0{$%s$}\typeout{%s %d \the\ht0\space\the\dp0}\usebox0`, latex, metricsMarker, id)
+}
+
+// readBoxMetrics extracts the box metrics recorded by measuredInlineMath
+// from a LaTeX log file, keyed by id
+func readBoxMetrics(logFile string) (map[int]boxMetrics, error) {
+	log, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		return nil, err
+	}
+	matches := metricsLine.FindAllSubmatch(log, -1)
+	if matches == nil {
+		return nil, fmt.Errorf("no box metrics found in '%s'", logFile)
+	}
+	metrics := make(map[int]boxMetrics, len(matches))
+	for _, m := range matches {
+		id, _ := strconv.Atoi(string(m[1]))
+		height, _ := strconv.ParseFloat(string(m[2]), 64)
+		depth, _ := strconv.ParseFloat(string(m[3]), 64)
+		metrics[id] = boxMetrics{height: height, depth: depth}
+	}
+	return metrics, nil
+}
+
+// depthPixels converts a box depth to pixels for an untrimmed page of
+// pixelHeight pixels, whose height is the box plus a border on each side
+func depthPixels(pixelHeight int, boxHeight, boxDepth, borderPt float64) int {
+	total := boxHeight + boxDepth + 2*borderPt
+	if total <= 0 {
+		return -1
+	}
+	return int(math.Round(float64(pixelHeight) * (boxDepth + borderPt) / total))
+}
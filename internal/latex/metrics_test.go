@@ -0,0 +1,61 @@
+package latex
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBoxMetrics(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "eq.log")
+	log := "This is pdfTeX\n(./eq.tex\nDMLMETRICS 0 6.83331pt 1.94397pt\n[1] [2]\nDMLMETRICS 2 4.30554pt 0.0pt\n)\n"
+	if err := ioutil.WriteFile(logFile, []byte(log), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	metrics, err := readBoxMetrics(logFile)
+	if err != nil {
+		t.Fatalf("readBoxMetrics failed: %v", err)
+	}
+	if m := metrics[0]; m.height != 6.83331 || m.depth != 1.94397 {
+		t.Errorf("metrics[0] = %+v, want {6.83331 1.94397}", m)
+	}
+	if m := metrics[2]; m.height != 4.30554 || m.depth != 0 {
+		t.Errorf("metrics[2] = %+v, want {4.30554 0}", m)
+	}
+	if _, ok := metrics[1]; ok {
+		t.Errorf("Unexpected metrics for id 1")
+	}
+
+	if err := ioutil.WriteFile(logFile, []byte("no metrics here"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if _, err := readBoxMetrics(logFile); err == nil {
+		t.Errorf("Expected error for log without metrics")
+	}
+}
+
+func TestDepthPixels(t *testing.T) {
+	tests := []struct {
+		name        string
+		pixelHeight int
+		height      float64
+		depth       float64
+		border      float64
+		want        int
+	}{
+		{"No depth, border only", 100, 6, 0, 2, 20},
+		{"Descender", 120, 6, 2, 2, 40},
+		{"No border", 80, 6, 2, 0, 20},
+		{"Degenerate box", 10, 0, 0, 0, -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := depthPixels(test.pixelHeight, test.height, test.depth, test.border)
+			if got != test.want {
+				t.Errorf("depthPixels = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
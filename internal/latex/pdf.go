@@ -0,0 +1,120 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pdfToPNGCommand returns the command that rasterises every page of pdfFile
+// at dpi to PNGs named <prefix>-<page>.png, counting from 1. pdftoppm
+// (poppler) is preferred, with mutool (MuPDF) as the fallback.
+func pdfToPNGCommand(pdfFile, prefix string, dpi int) (*exec.Cmd, error) {
+	res := strconv.Itoa(dpi)
+	if _, err := exec.LookPath("pdftoppm"); err == nil {
+		// pdftoppm pads the page number with zeros to the width of the last one
+		return exec.Command("pdftoppm", "-png", "-r", res, pdfFile, prefix), nil
+	}
+	if _, err := exec.LookPath("mutool"); err == nil {
+		return exec.Command("mutool", "draw", "-q", "-r", res, "-o", prefix+"-%d.png", pdfFile), nil
+	}
+	return nil, fmt.Errorf("no PDF rasteriser found on PATH (install pdftoppm or mutool)")
+}
+
+// rasterisePDFPages rasterises every page of pdfFile in one run of the
+// rasteriser and returns the PNGs in page order
+func (r *Renderer) rasterisePDFPages(ctx context.Context, pdfFile string, dpi int) ([][]byte, error) {
+	prefix := strings.TrimSuffix(pdfFile, ".pdf") + "-page"
+	cmd, err := pdfToPNGCommand(pdfFile, prefix, dpi)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Rasterising PDF: %s\n", strings.Join(cmd.Args, " "))
+	}
+	if err := runCommand(ctx, cmd, r.timeout); err != nil {
+		if errors.Is(err, ErrTimeout) {
+			return nil, fmt.Errorf("%s %w for PDF '%s'", cmd.Args[0], err, pdfFile)
+		}
+		return nil, rasteriserError(cmd.Args[0], fmt.Sprintf("PDF '%s'", pdfFile), stdout.Bytes(), stderr.Bytes(), err)
+	}
+
+	files, err := filepath.Glob(prefix + "-*.png")
+	if err != nil {
+		return nil, err
+	}
+	pageFiles := pngPages(prefix, files)
+	if len(pageFiles) == 0 {
+		return nil, fmt.Errorf("%s appeared to succeed but did not create any PNG for '%s'", cmd.Args[0], pdfFile)
+	}
+
+	pages := make([][]byte, len(pageFiles))
+	for i, pngFile := range pageFiles {
+		if pngFile == "" {
+			return nil, fmt.Errorf("%s did not create a PNG for page %d of '%s'", cmd.Args[0], i+1, pdfFile)
+		}
+		if pages[i], err = ioutil.ReadFile(pngFile); err != nil {
+			return nil, fmt.Errorf("failed to read PNG file '%s': %v", pngFile, err)
+		}
+	}
+	return pages, nil
+}
+
+// pngPages orders the rasteriser's output files <prefix>-<page>.png by page,
+// leaving "" for any page that is missing
+func pngPages(prefix string, files []string) []string {
+	byPage := make(map[int]string)
+	last := 0
+	for _, f := range files {
+		page, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(f, prefix+"-"), ".png"))
+		if err != nil || page < 1 {
+			continue
+		}
+		byPage[page] = f
+		if page > last {
+			last = page
+		}
+	}
+	ordered := make([]string, last)
+	for page, f := range byPage {
+		ordered[page-1] = f
+	}
+	return ordered
+}
+
+// renderDualPDF rasterises a PDF made of page pairs built by dualPages and
+// recovers the transparent image each pair shares. trim says, for each
+// pair, whether its image is trimmed to its ink.
+func (r *Renderer) renderDualPDF(ctx context.Context, pdfFile string, dpi int, trim []bool) ([][]byte, error) {
+	pages, err := r.rasterisePDFPages(ctx, pdfFile, dpi)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) < 2*len(trim) {
+		return nil, fmt.Errorf("PDF '%s' has %d pages, expected %d", pdfFile, len(pages), 2*len(trim))
+	}
+
+	imgs := make([][]byte, len(trim))
+	for j, t := range trim {
+		if imgs[j], err = composeDual(pages[2*j], pages[2*j+1], t); err != nil {
+			return nil, err
+		}
+	}
+
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Recovered alpha from %d page pairs of %s\n", len(trim), pdfFile)
+	}
+	return imgs, nil
+}
@@ -0,0 +1,19 @@
+package latex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPngPages(t *testing.T) {
+	// pdftoppm pads page numbers to the width of the last one; mutool doesn't
+	files := []string{"/tmp/eq-page-10.png", "/tmp/eq-page-02.png", "/tmp/eq-page-1.png", "/tmp/eq-page-x.png"}
+	got := pngPages("/tmp/eq-page", files)
+	want := []string{"/tmp/eq-page-1.png", "/tmp/eq-page-02.png", "", "", "", "", "", "", "", "/tmp/eq-page-10.png"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pngPages = %q, want %q", got, want)
+	}
+	if got := pngPages("/tmp/eq-page", nil); len(got) != 0 {
+		t.Errorf("pngPages of no files = %q, want none", got)
+	}
+}
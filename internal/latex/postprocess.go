@@ -0,0 +1,118 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+// unblend recovers the colour and alpha of content that was rasterised once
+// on black and once on white. A pixel of colour c and alpha a composites to
+// a*c on black and a*c + (1-a) on white, so the difference between the two
+// is 1-a in every channel and the black raster divided by a gives c.
+func unblend(onBlack, onWhite image.Image) (*image.NRGBA, error) {
+	bounds := onBlack.Bounds()
+	if bounds.Dx() != onWhite.Bounds().Dx() || bounds.Dy() != onWhite.Bounds().Dy() {
+		return nil, fmt.Errorf("rasters differ in size: %v on black, %v on white", bounds.Size(), onWhite.Bounds().Size())
+	}
+	offset := onWhite.Bounds().Min.Sub(bounds.Min)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			br, bg, bb, _ := onBlack.At(x, y).RGBA()
+			wr, wg, wb, _ := onWhite.At(x+offset.X, y+offset.Y).RGBA()
+
+			// Average the per-channel estimates of 1-a to smooth rounding
+			diff := (int64(wr) - int64(br) + int64(wg) - int64(bg) + int64(wb) - int64(bb)) / 3
+			alpha := clamp16(0xffff - diff)
+			if alpha>>8 == 0 {
+				continue
+			}
+
+			dst.SetNRGBA(x-bounds.Min.X, y-bounds.Min.Y, color.NRGBA{
+				R: uint8(clamp16(int64(br)*0xffff/alpha) >> 8),
+				G: uint8(clamp16(int64(bg)*0xffff/alpha) >> 8),
+				B: uint8(clamp16(int64(bb)*0xffff/alpha) >> 8),
+				A: uint8(alpha >> 8),
+			})
+		}
+	}
+	return dst, nil
+}
+
+// clamp16 limits v to the range of a 16-bit colour channel
+func clamp16(v int64) int64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 0xffff {
+		return 0xffff
+	}
+	return v
+}
+
+// trimTransparent crops img to the bounding box of its visible pixels. A
+// fully transparent image is returned unchanged.
+func trimTransparent(img *image.NRGBA) *image.NRGBA {
+	bounds := img.Bounds()
+	minX, minY, maxX, maxY := bounds.Max.X, bounds.Max.Y, bounds.Min.X, bounds.Min.Y
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if img.NRGBAAt(x, y).A == 0 {
+				continue
+			}
+			if x < minX {
+				minX = x
+			}
+			if y < minY {
+				minY = y
+			}
+			if x >= maxX {
+				maxX = x + 1
+			}
+			if y >= maxY {
+				maxY = y + 1
+			}
+		}
+	}
+	if minX >= maxX || minY >= maxY {
+		return img
+	}
+
+	crop := image.Rect(0, 0, maxX-minX, maxY-minY)
+	dst := image.NewNRGBA(crop)
+	draw.Draw(dst, crop, img, image.Pt(minX, minY), draw.Src)
+	return dst
+}
+
+// composeDual decodes the black and white rasters of a page, recovers its
+// transparency and optionally trims it, returning the encoded PNG
+func composeDual(onBlackPNG, onWhitePNG []byte, trim bool) ([]byte, error) {
+	onBlack, err := png.Decode(bytes.NewReader(onBlackPNG))
+	if err != nil {
+		return nil, fmt.Errorf("decoding page rendered on black: %v", err)
+	}
+	onWhite, err := png.Decode(bytes.NewReader(onWhitePNG))
+	if err != nil {
+		return nil, fmt.Errorf("decoding page rendered on white: %v", err)
+	}
+
+	img, err := unblend(onBlack, onWhite)
+	if err != nil {
+		return nil, err
+	}
+	if trim {
+		img = trimTransparent(img)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encoding PNG: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,116 @@
+package latex
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// compositeOn blends c at alpha a onto an opaque grey background level
+func compositeOn(c color.NRGBA, bg uint8) color.NRGBA {
+	blend := func(v uint8) uint8 {
+		return uint8((int(v)*int(c.A) + int(bg)*(0xff-int(c.A)) + 0x7f) / 0xff)
+	}
+	return color.NRGBA{R: blend(c.R), G: blend(c.G), B: blend(c.B), A: 0xff}
+}
+
+func TestUnblend(t *testing.T) {
+	pixels := []color.NRGBA{
+		{R: 0xff, G: 0x00, B: 0x00, A: 0xff}, // opaque red
+		{R: 0x00, G: 0x00, B: 0xff, A: 0x80}, // half-covered blue edge
+		{R: 0x40, G: 0xc0, B: 0x40, A: 0x20}, // faint green fringe
+		{A: 0},                               // background
+	}
+
+	onBlack := image.NewNRGBA(image.Rect(0, 0, len(pixels), 1))
+	onWhite := image.NewNRGBA(image.Rect(0, 0, len(pixels), 1))
+	for x, p := range pixels {
+		onBlack.SetNRGBA(x, 0, compositeOn(p, 0x00))
+		onWhite.SetNRGBA(x, 0, compositeOn(p, 0xff))
+	}
+
+	got, err := unblend(onBlack, onWhite)
+	if err != nil {
+		t.Fatalf("unblend failed: %v", err)
+	}
+
+	near := func(a, b uint8, tolerance int) bool {
+		d := int(a) - int(b)
+		return d >= -tolerance && d <= tolerance
+	}
+	for x, want := range pixels {
+		c := got.NRGBAAt(x, 0)
+		if !near(c.A, want.A, 1) {
+			t.Errorf("pixel %d: alpha = %d, want %d", x, c.A, want.A)
+			continue
+		}
+		// Colour precision drops as alpha falls
+		tolerance := 2
+		if want.A < 0x40 {
+			tolerance = 16
+		}
+		if want.A > 0 && (!near(c.R, want.R, tolerance) || !near(c.G, want.G, tolerance) || !near(c.B, want.B, tolerance)) {
+			t.Errorf("pixel %d: colour = %v, want %v", x, c, want)
+		}
+	}
+
+	if _, err := unblend(onBlack, image.NewNRGBA(image.Rect(0, 0, 1, 1))); err == nil {
+		t.Errorf("Expected error for rasters of different sizes")
+	}
+}
+
+func TestComposeDualTrim(t *testing.T) {
+	// A 6x4 page with a 2x2 white glyph
+	onBlack := image.NewNRGBA(image.Rect(0, 0, 6, 4))
+	onWhite := image.NewNRGBA(image.Rect(0, 0, 6, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 6; x++ {
+			onBlack.SetNRGBA(x, y, color.NRGBA{A: 0xff})
+			onWhite.SetNRGBA(x, y, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+		}
+	}
+	for y := 1; y < 3; y++ {
+		for x := 2; x < 4; x++ {
+			onBlack.SetNRGBA(x, y, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+		}
+	}
+
+	encode := func(img image.Image) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("png.Encode failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name          string
+		trim          bool
+		width, height int
+	}{
+		{"Untrimmed", false, 6, 4},
+		{"Trimmed", true, 2, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := composeDual(encode(onBlack), encode(onWhite), test.trim)
+			if err != nil {
+				t.Fatalf("composeDual failed: %v", err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("png.Decode failed: %v", err)
+			}
+			b := img.Bounds()
+			if b.Dx() != test.width || b.Dy() != test.height {
+				t.Errorf("Size = %dx%d, want %dx%d", b.Dx(), b.Dy(), test.width, test.height)
+			}
+			if _, _, _, a := img.At(0, 0).RGBA(); test.trim != (a == 0xffff) {
+				t.Errorf("Corner alpha = %d with trim=%v", a, test.trim)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import "os/exec"
+
+// setProcessGroup is a no-op where process groups aren't available
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills cmd; processes it started are left to the system
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -3,17 +3,21 @@ package latex
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"math"
 	"os"
 	"os/exec"
-	"strconv"
 	"strings"
 
 	"dml/internal/colour"
 )
 
+// cacheKeyVersion is mixed into every cache key; bump it whenever the
+// rendering pipeline changes in a way that alters the produced PNGs
+const cacheKeyVersion = "5"
+
 var isDebug bool
 
 // SetDebug enables or disables debug mode
@@ -21,292 +25,111 @@ func SetDebug(debug bool) {
 	isDebug = debug
 }
 
-// RenderMath renders a LaTeX math expression to a PNG image
-func RenderMath(latex string, colourStr string, isDisplay bool, dpi int, fuzzLevel string) ([]byte, error) {
-	// Skip empty latex content
-	latex = strings.TrimSpace(latex)
-	if latex == "" {
-		return nil, fmt.Errorf("empty LaTeX content")
+// RenderFullDocument renders an entire document as a single LaTeX image
+func (r *Renderer) RenderFullDocument(ctx context.Context, latexBody string, colourStr string, dpi int) (imgData []byte, err error) {
+	if !r.unsafe {
+		if err := checkSafe(latexBody, 0); err != nil {
+			return nil, err
+		}
 	}
 
-	// Add a small amount of spacing around display math for better rendering
-	if isDisplay {
-		latex = " " + latex + " "
-	}
+	textColour, latexcolourDefs := textColourDefs(colourStr)
 
-	if isDebug {
-		fmt.Fprintf(os.Stderr, "DEBUG: renderMath called with isDisplay=%v, dpi=%d\n", isDisplay, dpi)
-	}
+	// Fill the template. Both copies of the body must number their
+	// equations the same way.
+	body := `\setcounter{equation}{0}` + latexBody
+	tex := fmt.Sprintf(FullDocTemplate, r.docPreamble(true), latexcolourDefs, dualPages(textColour, body))
 
-	// Set default colour and prepare background colour
-	if colourStr == "" {
-		colourStr = "white"
+	// Create temporary directory, removed when done unless it is being kept
+	dir, err := newTempDir("dml-full")
+	if err != nil {
+		return nil, err
 	}
-	bg := "black"
-	transparent := "black"
-	latexcolourDefs := ""
+	defer func() { r.releaseTempDir(dir, err != nil) }()
 
-	// Process colours
-	if isDebug {
-		fmt.Fprintf(os.Stderr, "DEBUG: Processing colour: '%s'\n", colourStr)
+	texFile := dir + "/fulldoc.tex"
+	if err := ioutil.WriteFile(texFile, []byte(tex), 0644); err != nil {
+		return nil, err
 	}
 
-	hexcolour := colour.ToHex(colourStr)
-	if hexcolour != "" {
-		comp := colour.ComplementHex(hexcolour)
-		latexcolourDefs = colour.LaTeXcolourDef("usercolour", hexcolour) + colour.LaTeXcolourDef("bgcolour", comp)
-		colourStr = "usercolour"
-		bg = "bgcolour"
-		transparent = comp
-
-		if isDebug {
-			fmt.Fprintf(os.Stderr, "DEBUG: colour converted to hex: '%s', complement: '%s'\n", hexcolour, comp)
-		}
-	} else {
-		// fallback: use white text on black bg
-		colourStr = "white"
-		bg = "black"
-		transparent = "black"
-
-		if isDebug {
-			fmt.Fprintf(os.Stderr, "DEBUG: Using fallback colours: text='%s', bg='%s'\n", colourStr, bg)
-		}
+	// Compile to PDF. References are resolved from the .aux file written
+	// by the first run, so documents that use them are compiled twice.
+	runs := 1
+	if refCommand.MatchString(latexBody) {
+		runs = 2
 	}
-
-	// Prepare LaTeX content
-	var mathContent string
-	if isDisplay {
-		mathContent = fmt.Sprintf(`\[%s\]`, latex)
-		if isDebug {
-			fmt.Fprintf(os.Stderr, "DEBUG: Creating display math content with \\[ ... \\]\n")
-		}
-	} else {
-		mathContent = fmt.Sprintf(`$%s$`, latex)
-		if isDebug {
-			fmt.Fprintf(os.Stderr, "DEBUG: Creating inline math content with $ ... $\n")
+	for i := 0; i < runs; i++ {
+		if err := r.runEngine(ctx, dir, texFile, false, "", "full document"); err != nil {
+			return nil, err
 		}
 	}
 
-	// Fill the template
-	tex := fmt.Sprintf(TexTemplate, latexcolourDefs, bg, colourStr, mathContent)
-
-	// Create temporary directory
-	dir, err := ioutil.TempDir("", "dml")
+	// Rasterise the PDF and recover its transparency
+	imgs, err := r.renderDualPDF(ctx, dir+"/fulldoc.pdf", dpi, []bool{true})
 	if err != nil {
 		return nil, err
 	}
+	return imgs[0], nil
+}
 
-	texFile := dir + "/eq.tex"
-	if isDebug {
-		fmt.Fprintf(os.Stderr, "DEBUG: Created temp directory for LaTeX rendering: %s\n", dir)
-	}
-
-	// Write the TeX file
-	if err := ioutil.WriteFile(texFile, []byte(tex), 0644); err != nil {
-		os.RemoveAll(dir) // Clean up if tex file writing fails
-		return nil, err
-	}
-
-	// Compile to PDF
+// runEngine compiles texFile in dir with the renderer's engine, producing
+// DVI instead of PDF if asked and starting from format if one is given.
+// what describes the input for error messages.
+func (r *Renderer) runEngine(ctx context.Context, dir, texFile string, dvi bool, format, what string) error {
 	var stdout, stderr bytes.Buffer
-	cmd := exec.Command("pdflatex", "-interaction=nonstopmode", "-output-directory", dir, texFile)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		// If pdflatex fails, do not remove the temp directory so logs can be inspected
-		return nil, fmt.Errorf("pdflatex failed for '%s': %v\nLaTeX STDOUT:\n%s\nLaTeX STDERR:\n%s\nTemp dir: %s",
-			latex, err, stdout.String(), stderr.String(), dir)
+	cmd := r.engine.Command(dir, texFile, format, !r.unsafe)
+	if dvi {
+		cmd = r.engine.DVICommand(dir, texFile, format, !r.unsafe)
 	}
-
-	// Convert PDF to PNG
-	pdfFile := dir + "/eq.pdf"
-	pngFile := dir + "/eq.png"
-	stdout.Reset()
-	stderr.Reset()
-
-	// Determine fuzz level to use
-	effectiveFuzz := fuzzLevel
-	if effectiveFuzz == "" {
-		// Use dynamic fuzz calculation based on color
-		if hexcolour != "" {
-			effectiveFuzz = colour.GetFuzzLevel(hexcolour)
-			if isDebug {
-				fmt.Fprintf(os.Stderr, "DEBUG: Calculated dynamic fuzz level %s for color %s\n", effectiveFuzz, hexcolour)
-			}
-		} else {
-			effectiveFuzz = "45%" // Default fuzz level for non-hex colors
-		}
+	if !r.unsafe {
+		// Keep relative paths and every file TeX may touch inside dir
+		cmd.Dir = dir
+		cmd.Env = safeEnv(dir)
 	}
-
-	// Convert with extremely aggressive background removal
-	cmd = exec.Command("convert",
-		"-density", fmt.Sprintf("%d", dpi),
-		"-alpha", "on",
-		"-background", "none",
-		"-trim",
-		"+repage",
-		"-fuzz", effectiveFuzz,
-		"-transparent", transparent,
-		"-fuzz", effectiveFuzz,
-		"-transparent", transparent,
-		"-channel", "Alpha",
-		"-threshold", "50%",
-		"+channel",
-		"-background", "none",
-		"-flatten",
-		pdfFile, pngFile)
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("convert command failed for PDF '%s': %v\nConverter STDOUT:\n%s\nConverter STDERR:\n%s\nTemp dir: %s",
-			pdfFile, err, stdout.String(), stderr.String(), dir)
-	}
-
-	// Check if PNG file was created
-	if _, statErr := os.Stat(pngFile); os.IsNotExist(statErr) {
-		if isDebug {
-			fmt.Fprintf(os.Stderr, "DEBUG: PNG file not found after conversion: %s\n", pngFile)
-		}
-		return nil, fmt.Errorf("convert command appeared to succeed but did not create PNG '%s'.\nConverter STDOUT:\n%s\nConverter STDERR:\n%s\nTemp dir: %s\nStat error: %v",
-			pngFile, stdout.String(), stderr.String(), dir, statErr)
-	}
-
 	if isDebug {
-		fmt.Fprintf(os.Stderr, "DEBUG: PNG file successfully created: %s\n", pngFile)
+		fmt.Fprintf(os.Stderr, "DEBUG: Compiling with %s: %s\n", r.engine.Name(), strings.Join(cmd.Args, " "))
 	}
+	if err := runCommand(ctx, cmd, r.timeout); err != nil {
+		if errors.Is(err, ErrTimeout) {
+			return fmt.Errorf("%s %w for %s", r.engine.Name(), err, what)
+		}
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			// The engine never ran, so there is no log to report
+			return fmt.Errorf("%s failed for %s: %v", r.engine.Name(), what, err)
+		}
 
-	// Read the PNG file
-	imgData, readFileErr := ioutil.ReadFile(pngFile)
-	if readFileErr != nil {
-		return nil, fmt.Errorf("failed to read PNG file '%s': %v\nTemp dir: %s", pngFile, readFileErr, dir)
+		compileErr := &CompileError{Engine: r.engine.Name(), What: what, Dir: dir, Err: err}
+		if logData, logErr := ioutil.ReadFile(strings.TrimSuffix(texFile, ".tex") + ".log"); logErr == nil {
+			compileErr.Log = string(logData)
+		} else {
+			compileErr.Log = fmt.Sprintf("LaTeX STDOUT:\n%s\nLaTeX STDERR:\n%s", stdout.String(), stderr.String())
+		}
+		compileErr.parseLog(compileErr.Log)
+		return compileErr
 	}
-
-	os.RemoveAll(dir) // Clean up only on full success
-	return imgData, nil
+	return nil
 }
 
-// RenderFullDocument renders an entire document as a single LaTeX image
-func RenderFullDocument(latexBody string, colourStr string, dpi int, fuzzLevel string) ([]byte, error) {
-	if colourStr == "" {
-		colourStr = "white"
-	}
-	bg := "black"
-	transparent := "black"
-	latexcolourDefs := ""
-
-	// Process colours
+// textColourDefs returns the xcolor name to typeset in and any colour
+// definitions it needs. Unknown colours fall back to white.
+func textColourDefs(colourStr string) (name, defs string) {
 	if isDebug {
-		fmt.Fprintf(os.Stderr, "DEBUG: Processing colour for full document: '%s'\n", colourStr)
+		fmt.Fprintf(os.Stderr, "DEBUG: Processing colour: '%s'\n", colourStr)
 	}
 
 	hexcolour := colour.ToHex(colourStr)
-	if hexcolour != "" {
-		comp := colour.ComplementHex(hexcolour)
-		latexcolourDefs = colour.LaTeXcolourDef("usercolour", hexcolour) + colour.LaTeXcolourDef("bgcolour", comp)
-		colourStr = "usercolour"
-		bg = "bgcolour"
-		transparent = comp
-
-		if isDebug {
-			fmt.Fprintf(os.Stderr, "DEBUG: Full doc colour converted to hex: '%s', complement: '%s'\n", hexcolour, comp)
-		}
-	} else {
-		// fallback: use white text on black bg
-		colourStr = "white"
-		bg = "black"
-		transparent = "black"
-
+	if hexcolour == "" {
 		if isDebug {
-			fmt.Fprintf(os.Stderr, "DEBUG: Using fallback colours for full doc: text='%s', bg='%s'\n", colourStr, bg)
+			fmt.Fprintf(os.Stderr, "DEBUG: Unknown colour '%s', using white\n", colourStr)
 		}
+		return "white", ""
 	}
 
-	// Fill the template
-	tex := fmt.Sprintf(FullDocTemplate, latexcolourDefs, bg, colourStr, latexBody)
-
-	// Create temporary directory
-	dir, err := ioutil.TempDir("", "dml-full")
-	if err != nil {
-		return nil, err
-	}
-
-	texFile := dir + "/fulldoc.tex"
-	if err := ioutil.WriteFile(texFile, []byte(tex), 0644); err != nil {
-		os.RemoveAll(dir)
-		return nil, err
-	}
-
-	// Compile to PDF
-	var stdout, stderr bytes.Buffer
-	cmd := exec.Command("pdflatex", "-interaction=nonstopmode", "-output-directory", dir, texFile)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("pdflatex failed for full document: %v\nLaTeX STDOUT:\n%s\nLaTeX STDERR:\n%s\nTemp dir: %s",
-			err, stdout.String(), stderr.String(), dir)
-	}
-
-	// Convert PDF to PNG
-	pdfFile := dir + "/fulldoc.pdf"
-	pngFile := dir + "/fulldoc.png"
-	stdout.Reset()
-	stderr.Reset()
-
-	// Determine fuzz level to use
-	effectiveFuzz := fuzzLevel
-	if effectiveFuzz == "" {
-		// Use dynamic fuzz calculation based on color, but with a lower baseline for full documents
-		if hexcolour != "" {
-			// For full documents, we generally want a lower fuzz level
-			calculatedFuzz := colour.GetFuzzLevel(hexcolour)
-			// Convert percentage string to float, reduce it, and convert back
-			fuzzValue, _ := strconv.ParseFloat(strings.TrimSuffix(calculatedFuzz, "%"), 64)
-			fuzzValue = math.Max(2.0, fuzzValue * 0.15) // 15% of the regular fuzz value, minimum 2%
-			effectiveFuzz = fmt.Sprintf("%.1f%%", fuzzValue)
-			
-			if isDebug {
-				fmt.Fprintf(os.Stderr, "DEBUG: Calculated dynamic fuzz level %s for full document with color %s\n", 
-					effectiveFuzz, hexcolour)
-			}
-		} else {
-			effectiveFuzz = "2%" // Default fuzz level for non-hex colors in full documents
-		}
-	}
-
-	cmd = exec.Command("convert",
-		"-density", fmt.Sprintf("%d", dpi),
-		"-quality", "100",
-		"-trim",
-		"+repage",
-		"-fuzz", effectiveFuzz,
-		"-transparent", transparent,
-		"-fuzz", effectiveFuzz,
-		"-transparent", transparent,
-		"-channel", "Alpha",
-		"-threshold", "50%",
-		"+channel",
-		"-background", "none",
-		"-flatten",
-		pdfFile, pngFile)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("convert command failed for PDF '%s': %v\nConverter STDOUT:\n%s\nConverter STDERR:\n%s\nTemp dir: %s",
-			pdfFile, err, stdout.String(), stderr.String(), dir)
-	}
-
-	// Check if PNG file exists
-	if _, statErr := os.Stat(pngFile); os.IsNotExist(statErr) {
-		return nil, fmt.Errorf("convert command appeared to succeed but did not create PNG '%s'.\nConverter STDOUT:\n%s\nConverter STDERR:\n%s\nTemp dir: %s",
-			pngFile, stdout.String(), stderr.String(), dir)
-	}
-
-	// Read the PNG file
-	imgData, readFileErr := ioutil.ReadFile(pngFile)
-	if readFileErr != nil {
-		return nil, fmt.Errorf("failed to read PNG file '%s': %v\nTemp dir: %s", pngFile, readFileErr, dir)
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: colour converted to hex: '%s'\n", hexcolour)
 	}
-
-	os.RemoveAll(dir)
-	return imgData, nil
+	return "usercolour", colour.LaTeXcolourDef("usercolour", hexcolour)
 }
@@ -1,12 +1,13 @@
 package latex
 
 import (
+	"context"
 	"os"
 	"testing"
 )
 
 // Mock test for RenderMath function
-// Note: Full testing requires LaTeX and ImageMagick installed
+// Note: Full testing requires LaTeX and pdftoppm installed
 func TestRenderMath(t *testing.T) {
 	// This test can be run in two modes:
 	// 1. Mock mode (default): Skip actual rendering, just test parameter handling
@@ -18,7 +19,7 @@ func TestRenderMath(t *testing.T) {
 	// Check if we should attempt real rendering
 	if os.Getenv("DML_TEST_FULL_RENDERING") == "1" &&
 	   fileExists("/usr/bin/pdflatex") &&
-	   fileExists("/usr/bin/convert") {
+	   fileExists("/usr/bin/pdftoppm") {
 		skipActualRendering = false
 	}
 
@@ -26,7 +27,6 @@ func TestRenderMath(t *testing.T) {
 		name       string
 		latex      string
 		colour      string
-		fuzzLevel string
 		isDisplay  bool
 		dpi        int
 		shouldFail bool
@@ -88,7 +88,11 @@ func TestRenderMath(t *testing.T) {
 			}
 
 			// Only run these tests if we're doing actual rendering
-			img, err := RenderMath(test.latex, test.colour, test.isDisplay, test.dpi, test.fuzzLevel)
+			r, err := NewRenderer(Options{Rasteriser: RasteriserPDF})
+			if err != nil {
+				t.Fatalf("NewRenderer: %v", err)
+			}
+			img, err := r.RenderMath(context.Background(), test.latex, test.colour, test.isDisplay, test.dpi)
 
 			if test.shouldFail {
 				if err == nil {
@@ -102,9 +106,13 @@ func TestRenderMath(t *testing.T) {
 				return
 			}
 
-			if len(img) == 0 {
+			if len(img.PNG) == 0 {
 				t.Errorf("Expected non-empty image data")
 			}
+
+			if !test.isDisplay && (img.Depth < 0 || img.Depth > img.Height) {
+				t.Errorf("Expected inline baseline depth within image, got %d for height %d", img.Depth, img.Height)
+			}
 		})
 	}
 }
@@ -127,7 +135,7 @@ func TestRenderFullDocument(t *testing.T) {
 	// Check if we should attempt real rendering
 	if os.Getenv("DML_TEST_FULL_RENDERING") == "1" &&
 	   fileExists("/usr/bin/pdflatex") &&
-	   fileExists("/usr/bin/convert") {
+	   fileExists("/usr/bin/pdftoppm") {
 		// skipActualRendering = false
 	}
 
@@ -137,7 +145,6 @@ func TestRenderFullDocument(t *testing.T) {
 		colour      string
 		dpi        int
 		shouldFail bool
-		fuzzLevel string
 	}{
 		{
 			name:       "Empty document",
@@ -145,7 +152,6 @@ func TestRenderFullDocument(t *testing.T) {
 			colour:      "white",
 			dpi:        300,
 			shouldFail: false,
-			fuzzLevel: "10%",
 		},
 		{
 			name:       "Simple document",
@@ -153,7 +159,6 @@ func TestRenderFullDocument(t *testing.T) {
 			colour:      "white",
 			dpi:        300,
 			shouldFail: false,
-			fuzzLevel: "10%",
 		},
 		{
 			name:       "Document with math",
@@ -161,7 +166,6 @@ func TestRenderFullDocument(t *testing.T) {
 			colour:      "white",
 			dpi:        300,
 			shouldFail: false,
-			fuzzLevel: "10%",
 		},
 		{
 			name:       "Custom colour",
@@ -169,7 +173,6 @@ func TestRenderFullDocument(t *testing.T) {
 			colour:      "blue",
 			dpi:        300,
 			shouldFail: false,
-			fuzzLevel: "10%",
 		},
 		{
 			name:       "Invalid LaTeX",
@@ -177,7 +180,6 @@ func TestRenderFullDocument(t *testing.T) {
 			colour:      "white",
 			dpi:        300,
 			shouldFail: true,
-			fuzzLevel: "10%",
 		},
 	}
 
@@ -186,16 +188,21 @@ func TestRenderFullDocument(t *testing.T) {
 		t.Skip("pdflatex not found, skipping actual LaTeX tests")
 	}
 
-	if _, err := os.Stat("/usr/bin/convert"); os.IsNotExist(err) {
-		t.Skip("convert (ImageMagick) not found, skipping actual LaTeX tests")
+	if _, err := os.Stat("/usr/bin/pdftoppm"); os.IsNotExist(err) {
+		t.Skip("pdftoppm not found, skipping actual LaTeX tests")
 	}
 
 	// Set debug mode to see detailed output
 	SetDebug(true)
 
+	r, err := NewRenderer(Options{Rasteriser: RasteriserPDF})
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			img, err := RenderFullDocument(test.latexBody, test.colour, test.dpi, test.fuzzLevel)
+			img, err := r.RenderFullDocument(context.Background(), test.latexBody, test.colour, test.dpi)
 
 			if test.shouldFail {
 				if err == nil {
@@ -0,0 +1,185 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"dml/internal/cache"
+)
+
+// Renderer renders LaTeX with a fixed engine, rasteriser and cache. It holds
+// all rendering state and every render works in its own temp dir, so one
+// Renderer can be used from many goroutines at once.
+type Renderer struct {
+	engine     Engine
+	rasteriser string
+	preamble   string       // user LaTeX added after the standard preamble
+	cache      *cache.Cache // nil disables the disk cache
+	formatDir  string       // empty disables precompiled formats
+	timeout    time.Duration
+	unsafe     bool
+	keepTemp   bool
+
+	// format is the precompiled math preamble, dumped on first use
+	formatOnce sync.Once
+	format     string
+
+	// memo holds the outcomes of the renders most recently attempted or
+	// loaded by this Renderer, so that expressions prerendered by RenderBatch
+	// are found without a disk cache and broken expressions are only
+	// compiled once. memoOrder lists its entries, most recently used first.
+	memoMu    sync.Mutex
+	memo      map[string]*list.Element
+	memoOrder *list.List
+}
+
+// memoLimit is how many render outcomes a Renderer keeps in memory. Older
+// ones are found in the disk cache or rendered again.
+const memoLimit = 512
+
+// memoEntry is a render outcome in the memo
+type memoEntry struct {
+	key string
+	res renderResult
+}
+
+// renderResult is a finished render: an image or the error that prevented it
+type renderResult struct {
+	img *Image
+	err error
+}
+
+// Options configures a Renderer
+type Options struct {
+	Engine     Engine        // TeX engine; pdflatex if nil
+	Rasteriser string        // rasteriser name (see RasteriserAuto); auto if empty
+	Preamble   string        // extra LaTeX for the preamble of every document, e.g. from UsePackage
+	Cache      *cache.Cache  // disk cache; nil disables it
+	FormatDir  string        // where precompiled formats are kept; empty disables them
+	Timeout    time.Duration // limit on each LaTeX or rasteriser command; 0 for none
+	Unsafe     bool          // trust the input: skip the safe mode checks and restrictions
+	KeepTemp   bool          // keep every render's temp dir instead of removing it
+}
+
+// NewRenderer creates a Renderer configured by opts
+func NewRenderer(opts Options) (*Renderer, error) {
+	engine := opts.Engine
+	if engine == nil {
+		engine = Engines[0]
+	}
+	resolved, err := resolveRasteriser(opts.Rasteriser, engine)
+	if err != nil {
+		return nil, err
+	}
+	return &Renderer{
+		engine:     engine,
+		rasteriser: resolved,
+		preamble:   opts.Preamble,
+		cache:      opts.Cache,
+		formatDir:  opts.FormatDir,
+		timeout:    opts.Timeout,
+		unsafe:     opts.Unsafe,
+		keepTemp:   opts.KeepTemp,
+		memo:       make(map[string]*list.Element),
+		memoOrder:  list.New(),
+	}, nil
+}
+
+// Engine returns the engine the renderer compiles with
+func (r *Renderer) Engine() Engine {
+	return r.engine
+}
+
+// Unsafe reports whether safe mode is off
+func (r *Renderer) Unsafe() bool {
+	return r.unsafe
+}
+
+// Rasteriser returns the resolved rasteriser, RasteriserPDF or RasteriserDVIPNG
+func (r *Renderer) Rasteriser() string {
+	return r.rasteriser
+}
+
+// RenderMath renders a LaTeX math expression to a PNG image. Inline
+// expressions are returned untrimmed with their baseline depth so the
+// terminal can align them with the surrounding text.
+func (r *Renderer) RenderMath(ctx context.Context, latex string, colourStr string, isDisplay bool, dpi int) (*Image, error) {
+	return r.RenderExpr(ctx, MathExpr{LaTeX: latex, Display: isDisplay}, colourStr, dpi)
+}
+
+// RenderExpr renders one math expression like RenderMath, typesetting its
+// macro definitions first
+func (r *Renderer) RenderExpr(ctx context.Context, expr MathExpr, colourStr string, dpi int) (*Image, error) {
+	imgs, errs := r.RenderBatch(ctx, []MathExpr{expr}, colourStr, dpi)
+	return imgs[0], errs[0]
+}
+
+// mathCacheKey identifies a render of latex (already normalised by
+// RenderBatch) after macros in the memo and disk cache
+func (r *Renderer) mathCacheKey(macros, latex, colourStr string, isDisplay bool, dpi int) string {
+	return cache.Key(cacheKeyVersion, r.engine.Name(), r.rasteriser, r.preamble, macros, latex, colourStr, strconv.Itoa(dpi), strconv.FormatBool(isDisplay))
+}
+
+// lookupRender returns the outcome of a previous render by this renderer,
+// or an image from the disk cache
+func (r *Renderer) lookupRender(key string) (renderResult, bool) {
+	r.memoMu.Lock()
+	elem, ok := r.memo[key]
+	var res renderResult
+	if ok {
+		r.memoOrder.MoveToFront(elem)
+		// Copy under the lock: rememberRender may update the entry in place
+		res = elem.Value.(*memoEntry).res
+	}
+	r.memoMu.Unlock()
+	if ok {
+		return res, true
+	}
+
+	if r.cache != nil {
+		if data, meta, ok := r.cache.Get(key); ok {
+			img := &Image{PNG: data, Width: meta.Width, Height: meta.Height, Depth: meta.Depth}
+			r.rememberRender(key, img, nil)
+			return renderResult{img: img}, true
+		}
+	}
+	return renderResult{}, false
+}
+
+// rememberRender keeps the outcome of a render in the memo, forgetting the
+// least recently used outcome once there are more than memoLimit
+func (r *Renderer) rememberRender(key string, img *Image, err error) {
+	r.memoMu.Lock()
+	defer r.memoMu.Unlock()
+
+	res := renderResult{img: img, err: err}
+	if elem, ok := r.memo[key]; ok {
+		elem.Value.(*memoEntry).res = res
+		r.memoOrder.MoveToFront(elem)
+		return
+	}
+	r.memo[key] = r.memoOrder.PushFront(&memoEntry{key: key, res: res})
+	for r.memoOrder.Len() > memoLimit {
+		oldest := r.memoOrder.Back()
+		r.memoOrder.Remove(oldest)
+		delete(r.memo, oldest.Value.(*memoEntry).key)
+	}
+}
+
+// storeRender remembers a fresh render and writes it to the disk cache
+func (r *Renderer) storeRender(key string, img *Image) {
+	r.rememberRender(key, img, nil)
+
+	// Cache failures must never break rendering, so they are only reported
+	if r.cache != nil {
+		if err := r.cache.Put(key, img.PNG, cache.Meta{Depth: img.Depth}); err != nil && isDebug {
+			fmt.Fprintf(os.Stderr, "DEBUG: Failed to store render in cache: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// MaxSafeLength caps the length in bytes of a math expression in safe mode
+const MaxSafeLength = 4096
+
+// controlWord matches a control word, counting @ as a letter so internal
+// macros are caught whether or not \makeatletter is in effect. expl3 names
+// like \use:c only exist under \ExplSyntaxOn, which is forbidden.
+var controlWord = regexp.MustCompile(`\\([A-Za-z@]+)`)
+
+// environmentName matches the name in \begin{...} or \end{...}
+var environmentName = regexp.MustCompile(`\\(?:begin|end)\s*\{\s*([^}]*?)\s*\}`)
+
+// forbiddenCommands can read or write files, run programs, run Lua or
+// change how TeX reads its input. Math needs none of them.
+var forbiddenCommands = map[string]bool{
+	// File input
+	"input": true, "include": true, "includeonly": true, "InputIfFileExists": true,
+	"IfFileExists": true, "openin": true, "read": true, "readline": true, "closein": true,
+	"verbatiminput": true, "endinput": true, "usepackage": true, "RequirePackage": true,
+	"documentclass": true, "LoadClass": true, "pdffiledump": true, "pdfmdfivesum": true,
+	"pdffilesize": true, "pdffilemoddate": true, "pdfximage": true, "pdfobj": true,
+	"filedump": true, "filesize": true, "filemoddate": true, "mdfivesum": true,
+	"includegraphics": true, "lstinputlisting": true, "VerbatimInput": true, "import": true,
+	"subimport": true, "inputminted": true, "bibliography": true, "addbibresource": true,
+	// File output and shell escape
+	"openout": true, "write": true, "immediate": true, "closeout": true, "write18": true,
+	"ShellEscape": true, "special": true,
+	// Lua
+	"directlua": true, "latelua": true, "luaexec": true, "luadirect": true, "luacode": true,
+	"luafunction": true, "luafunctioncall": true, "luadef": true, "luabytecode": true,
+	"luabytecodecall": true, "luaescapestring": true,
+	// Changing how input is read, or building forbidden names indirectly
+	"catcode": true, "catcodetable": true, "initcatcodetable": true, "savecatcodetable": true,
+	"lccode": true, "uccode": true, "scantokens": true,
+	"everyjob": true, "everyeof": true, "endlinechar": true, "newlinechar": true,
+	"csname": true, "ifcsname": true, "begincsname": true, "lastnamedcs": true,
+	"UseName": true, "ExpandArgs": true, "ExplSyntaxOn": true, "ProvidesExplPackage": true,
+	"ProvidesExplClass": true, "ProvidesExplFile": true,
+}
+
+// forbiddenEnvironments write files or run Lua
+var forbiddenEnvironments = map[string]bool{
+	"filecontents": true, "filecontents*": true, "luacode": true, "luacode*": true,
+	"luacodestar": true, "VerbatimOut": true,
+}
+
+// checkSafe reports why latex must not be compiled in safe mode. maxLength
+// caps its length in bytes unless it is zero.
+func checkSafe(latex string, maxLength int) error {
+	if maxLength > 0 && len(latex) > maxLength {
+		return fmt.Errorf("expression of %d bytes exceeds the safe mode limit of %d", len(latex), maxLength)
+	}
+
+	// ^^ notation spells any character, including the backslash of a
+	// forbidden command, in a way the denylist can't see
+	if strings.Contains(latex, "^^") {
+		return fmt.Errorf("'^^' character codes are not allowed in safe mode")
+	}
+
+	for _, m := range controlWord.FindAllStringSubmatch(latex, -1) {
+		name := m[1]
+		if forbiddenCommands[name] || strings.Contains(name, "@") {
+			if isDebug {
+				fmt.Fprintf(os.Stderr, "DEBUG: Safe mode rejected \\%s in '%s'\n", name, latex)
+			}
+			return fmt.Errorf("\\%s is not allowed in safe mode", name)
+		}
+	}
+	for _, m := range environmentName.FindAllStringSubmatch(latex, -1) {
+		if forbiddenEnvironments[m[1]] {
+			if isDebug {
+				fmt.Fprintf(os.Stderr, "DEBUG: Safe mode rejected environment %s in '%s'\n", m[1], latex)
+			}
+			return fmt.Errorf("the %s environment is not allowed in safe mode", m[1])
+		}
+	}
+	return nil
+}
+
+// safeEnv returns the environment for an engine working in dir in safe
+// mode. kpathsea's paranoid settings stop TeX reading or writing dot files
+// and anything outside the working tree and TEXMFOUTPUT, which is dir.
+func safeEnv(dir string) []string {
+	return append(os.Environ(),
+		"openin_any=p",
+		"openout_any=p",
+		"shell_escape=f",
+		"TEXMFOUTPUT="+dir)
+}
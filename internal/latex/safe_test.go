@@ -0,0 +1,76 @@
+package latex
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheckSafe(t *testing.T) {
+	allowed := []string{
+		`x^2 + y^2 = z^2`,
+		`\frac{a}{b} \cdot \sqrt{\alpha}`,
+		`\newcommand{\R}{\mathbb{R}} \R^n`,
+		`\text{input} \in S`,
+		`\begin{pmatrix} 1 & 0 \\ 0 & 1 \end{pmatrix}`,
+		`\sum_{i=1}^n a_i \quad f\colon A \to B`,
+	}
+	for _, latex := range allowed {
+		if err := checkSafe(latex, MaxSafeLength); err != nil {
+			t.Errorf("checkSafe(%q) = %v, want allowed", latex, err)
+		}
+	}
+
+	rejected := []string{
+		`\input{/etc/passwd}`,
+		`x \include{secrets}`,
+		`\immediate\write18{rm -rf ~}`,
+		`\openout1=out.tex`,
+		"\\catcode`\\^^5c=12",
+		`\csname input\endcsname{/etc/passwd}`,
+		`\^^69nput{/etc/passwd}`,
+		`\makeatletter\@input{/etc/passwd}`,
+		`\directlua{os.execute("id")}`,
+		`\latelua{os.execute("id")}`,
+		`\luaexec{os.execute("id")}`,
+		`\catcodetable0 \input{/etc/passwd}`,
+		`\read16 to \x`,
+		`\UseName{input}{/etc/passwd}`,
+		`\UseName{directlua}{os.execute("id")}`,
+		`\ExpandArgs{c}\def{input}`,
+		`\ifcsname directlua\endcsname\expandafter\lastnamedcs\fi{os.execute("id")}`,
+		`\begincsname input\endcsname{/etc/passwd}`,
+		`\ExplSyntaxOn \lua_now:e{os.execute("id")}`,
+		`\ExplSyntaxOn \use:c{input}{/etc/passwd}`,
+		`\ExplSyntaxOn \file_input:n{/etc/passwd}`,
+		`\begin{filecontents}{x.tex}\end{filecontents}`,
+		`\begin{filecontents*}[overwrite]{x.tex}\end{filecontents*}`,
+		`\begin { luacode } os.execute("id") \end{luacode}`,
+	}
+	for _, latex := range rejected {
+		if err := checkSafe(latex, MaxSafeLength); err == nil {
+			t.Errorf("checkSafe(%q) allowed, want rejected", latex)
+		}
+	}
+
+	long := strings.Repeat("x+", MaxSafeLength)
+	if err := checkSafe(long, MaxSafeLength); err == nil {
+		t.Errorf("Expected over-long expression to be rejected")
+	}
+	if err := checkSafe(long, 0); err != nil {
+		t.Errorf("checkSafe without a limit = %v, want allowed", err)
+	}
+}
+
+func TestRenderBatchSafeMode(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	r, err := NewRenderer(Options{Rasteriser: RasteriserPDF})
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+
+	_, errs := r.RenderBatch(context.Background(), []MathExpr{{LaTeX: `\input{/etc/passwd}`}}, "white", 300)
+	if errs[0] == nil || !strings.Contains(errs[0].Error(), "safe mode") {
+		t.Errorf("Expected safe mode rejection, got %v", errs[0])
+	}
+}
@@ -0,0 +1,70 @@
+// Package latex provides LaTeX rendering functionality for DML
+package latex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// StaleTempAge is how old a leftover temp dir must be before SweepTempDirs
+// removes it. Renders take seconds, so anything this old is abandoned.
+const StaleTempAge = 24 * time.Hour
+
+// tempDirName matches the names newTempDir gives its directories
+var tempDirName = regexp.MustCompile(`^dml(-full)?[0-9]+$`)
+
+// newTempDir creates a private working directory for one LaTeX run.
+// kind is "dml" for math and "dml-full" for full documents.
+func newTempDir(kind string) (string, error) {
+	return ioutil.TempDir("", kind)
+}
+
+// releaseTempDir removes a render's temp dir when it is done, unless temp
+// dirs are being kept for inspection
+func (r *Renderer) releaseTempDir(dir string, failed bool) {
+	if r.keepTemp {
+		if isDebug {
+			status := "succeeded"
+			if failed {
+				status = "failed"
+			}
+			fmt.Fprintf(os.Stderr, "DEBUG: Keeping temp dir of render that %s: %s\n", status, dir)
+		}
+		return
+	}
+	os.RemoveAll(dir)
+}
+
+// SweepTempDirs removes temp dirs left in the system temp directory by
+// earlier runs (killed, or run with KeepTemp) that haven't changed for
+// maxAge. It returns how many it removed.
+func SweepTempDirs(maxAge time.Duration) int {
+	tmp := os.TempDir()
+	files, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		return 0
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, f := range files {
+		if !f.IsDir() || !tempDirName.MatchString(f.Name()) || !f.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(tmp, f.Name())); err != nil {
+			if isDebug {
+				fmt.Fprintf(os.Stderr, "DEBUG: Failed to remove stale temp dir %s: %v\n", f.Name(), err)
+			}
+			continue
+		}
+		removed++
+	}
+	if isDebug && removed > 0 {
+		fmt.Fprintf(os.Stderr, "DEBUG: Removed %d stale temp dirs from %s\n", removed, tmp)
+	}
+	return removed
+}
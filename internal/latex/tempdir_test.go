@@ -0,0 +1,58 @@
+package latex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSweepTempDirs(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	old := time.Now().Add(-2 * StaleTempAge)
+	create := func(name string, dir bool, modTime time.Time) {
+		path := filepath.Join(tmp, name)
+		if dir {
+			os.Mkdir(path, 0755)
+			ioutil.WriteFile(filepath.Join(path, "eq.tex"), nil, 0644)
+		} else {
+			ioutil.WriteFile(path, nil, 0644)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+	create("dml123", true, old)        // stale: removed
+	create("dml-full456", true, old)   // stale: removed
+	create("dml789", true, time.Now()) // in use: kept
+	create("dmlnotes", true, old)      // not ours: kept
+	create("dml42", false, old)        // not a directory: kept
+
+	if got := SweepTempDirs(StaleTempAge); got != 2 {
+		t.Errorf("SweepTempDirs removed %d dirs, want 2", got)
+	}
+	for name, want := range map[string]bool{"dml123": false, "dml-full456": false, "dml789": true, "dmlnotes": true, "dml42": true} {
+		_, err := os.Stat(filepath.Join(tmp, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %v, want %v", name, exists, want)
+		}
+	}
+}
+
+func TestReleaseTempDir(t *testing.T) {
+	r := &Renderer{}
+	dir := t.TempDir() + "/dml1"
+	os.Mkdir(dir, 0755)
+	r.releaseTempDir(dir, true)
+	if _, err := os.Stat(dir); err == nil {
+		t.Errorf("Failed render's temp dir was not removed")
+	}
+
+	r.keepTemp = true
+	os.Mkdir(dir, 0755)
+	r.releaseTempDir(dir, true)
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("Temp dir was removed despite KeepTemp")
+	}
+}
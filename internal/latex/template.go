@@ -1,34 +1,112 @@
 // Package latex provides LaTeX template functionality for DML
 package latex
 
-// TexTemplate is the LaTeX document template for rendering math expressions
-const TexTemplate = `\documentclass[border=2pt,preview]{standalone}
+import (
+	"fmt"
+	"regexp"
+)
+
+// TexTemplate is the LaTeX document template for rendering math expressions.
+// Its verbs are the preamble from docPreamble, colour definitions and the pages,
+// each of which is built with texPage.
+const TexTemplate = mathPreamble + mathBody
+
+// mathPreamble is the part of TexTemplate that precompiled formats preload.
+// Its verb is the preamble from docPreamble.
+const mathPreamble = `\documentclass[border=2pt,preview,multi=dmlpage]{standalone}
 \usepackage{amsmath}
 \usepackage{amssymb}
 \usepackage{amsfonts}
 \usepackage{mathtools}
 \usepackage[dvipsnames,svgnames,table]{xcolor}
 %s
+`
+
+// mathBody is the rest of TexTemplate, which is all a document compiled
+// from a precompiled format contains. Its verbs are the colour definitions
+// and the pages.
+const mathBody = `%s
 \begin{document}
-\pagecolor{%s}
-\color{%s}
 %s
 \end{document}`
 
-// FullDocTemplate is the LaTeX document template for rendering entire documents
-const FullDocTemplate = `\documentclass[border=3pt,preview]{standalone}
+// FullDocTemplate is the LaTeX document template for rendering entire
+// documents. Its verbs match TexTemplate.
+const FullDocTemplate = `\documentclass[border=3pt,preview,multi=dmlpage]{standalone}
 \usepackage{amsmath}
 \usepackage{amssymb}
 \usepackage{amsfonts}
 \usepackage{mathtools}
 \usepackage[dvipsnames,svgnames,table]{xcolor}
-\usepackage[utf8]{inputenc}
-\usepackage[T1]{fontenc}
-\usepackage{lmodern}
 \usepackage{verbatim}
 %s
+%s
 \begin{document}
-\pagecolor{%s}
-\color{%s}
 %s
-\end{document}`
\ No newline at end of file
+\end{document}`
+
+// pdfTeXDocFonts sets up 8-bit fonts for full documents under pdfTeX; math
+// expressions need nothing beyond the default Computer Modern fonts
+const pdfTeXDocFonts = `\usepackage[utf8]{inputenc}
+\usepackage[T1]{fontenc}
+\usepackage{lmodern}`
+
+// unicodeFonts loads OpenType text and math fonts under XeTeX and LuaTeX.
+// unicode-math must come after the AMS packages it overrides.
+const unicodeFonts = `\usepackage{fontspec}
+\usepackage{unicode-math}`
+
+// fontPreamble returns the font setup for e, for either a math expression
+// or a full document
+func fontPreamble(e Engine, fullDoc bool) string {
+	if e.Unicode() {
+		return unicodeFonts
+	}
+	if fullDoc {
+		return pdfTeXDocFonts
+	}
+	return ""
+}
+
+// docPreamble returns the font setup for the renderer's engine followed by the
+// user preamble, filling the first verb of TexTemplate or FullDocTemplate
+func (r *Renderer) docPreamble(fullDoc bool) string {
+	fonts := fontPreamble(r.engine, fullDoc)
+	if r.preamble == "" {
+		return fonts
+	}
+	return fonts + "\n" + r.preamble
+}
+
+// packageSpec matches NAME or NAME[options] as given to UsePackage
+var packageSpec = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9_.-]*)(?:\[([^\[\]{}\\]*)\])?$`)
+
+// UsePackage returns the \usepackage line for spec, a package name with
+// optional options in brackets such as "physics" or "siunitx[per-mode=symbol]"
+func UsePackage(spec string) (string, error) {
+	m := packageSpec.FindStringSubmatch(spec)
+	if m == nil {
+		return "", fmt.Errorf("invalid package '%s': want NAME or NAME[options]", spec)
+	}
+	if m[2] != "" {
+		return fmt.Sprintf("\\usepackage[%s]{%s}", m[2], m[1]), nil
+	}
+	return fmt.Sprintf("\\usepackage{%s}", m[1]), nil
+}
+
+// texPage returns one standalone page of content in textColour. An empty
+// pageColour leaves the page transparent; \pagecolor is global, so every
+// page that needs a background must set its own.
+func texPage(pageColour, textColour, content string) string {
+	page := fmt.Sprintf("\\begin{dmlpage}\\color{%s}%s\\end{dmlpage}\n", textColour, content)
+	if pageColour != "" {
+		page = fmt.Sprintf("\\pagecolor{%s}\n", pageColour) + page
+	}
+	return page
+}
+
+// dualPages typesets content twice, on a black page and then on a white
+// page, so that exact alpha can be recovered from the two rasters
+func dualPages(textColour, content string) string {
+	return texPage("black", textColour, content) + texPage("white", textColour, content)
+}
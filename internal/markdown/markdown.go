@@ -3,6 +3,7 @@ package markdown
 
 import (
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -214,7 +215,7 @@ func GenerateLatexFromAST(node ast.Node, sb *strings.Builder) {
 	case *ast.Document:
 		processChildren(n)
 	case *ast.Text:
-		sb.WriteString(latex.EscapeLaTeX(string(n.Literal)))
+		sb.WriteString(escapeText(string(n.Literal)))
 	case *ast.Emph:
 		sb.WriteString(`\textit{`)
 		processChildren(n)
@@ -299,9 +300,7 @@ func GenerateLatexFromAST(node ast.Node, sb *strings.Builder) {
 		sb.WriteString(string(n.Literal))
 		sb.WriteString(`$`)
 	case *ast.MathBlock:
-		sb.WriteString(`$$`)
-		sb.WriteString(string(n.Literal))
-		sb.WriteString(`$$`)
+		sb.WriteString(latex.DisplayMathBlock(string(n.Literal)))
 	case *ast.Paragraph:
 		processChildren(n)
 		sb.WriteString("\n\\par\n\n")
@@ -337,6 +336,22 @@ func GenerateLatexFromAST(node ast.Node, sb *strings.Builder) {
 	}
 }
 
+// reference matches \ref and \eqref, which LaTeX resolves in full documents
+var reference = regexp.MustCompile(`\\(?:eq)?ref\{[^{}\\]*\}`)
+
+// escapeText escapes text for LaTeX, keeping references to equations
+func escapeText(text string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range reference.FindAllStringIndex(text, -1) {
+		sb.WriteString(latex.EscapeLaTeX(text[last:loc[0]]))
+		sb.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	sb.WriteString(latex.EscapeLaTeX(text[last:]))
+	return sb.String()
+}
+
 // RenderMarkdownAST recursively traverses the AST and builds a string with ANSI codes
 func RenderMarkdownAST(node ast.Node, sb *strings.Builder) {
 	if node == nil {
@@ -131,6 +131,11 @@ func TestGenerateLatexFromAST(t *testing.T) {
 			markdown: "Paragraph 1\n\nParagraph 2",
 			expected: "Paragraph 1\n\\par\n\nParagraph 2\n\\par\n\n",
 		},
+		{
+			name:     "Equation reference",
+			markdown: "By \\eqref{eq:euler} and \\ref{eq:2}, 50% holds",
+			expected: "By \\eqref{eq:euler} and \\ref{eq:2}, 50\\% holds",
+		},
 	}
 
 	for _, test := range tests {
@@ -149,6 +154,28 @@ func TestGenerateLatexFromAST(t *testing.T) {
 	}
 }
 
+func TestGenerateLatexDisplayMath(t *testing.T) {
+	tests := []struct {
+		markdown string
+		expected string
+	}{
+		{"$$x^2$$", "$$x^2$$"},
+		{"$$e^{i\\pi} = -1 \\label{eq:euler}$$", "\\begin{equation}e^{i\\pi} = -1 \\label{eq:euler}\\end{equation}"},
+		{"$$\\begin{align}a &= b\\end{align}$$", "\\begin{align}a &= b\\end{align}"},
+	}
+
+	for _, test := range tests {
+		p := parser.NewWithExtensions(parser.CommonExtensions | parser.MathJax)
+		doc := p.Parse([]byte(test.markdown))
+
+		var sb strings.Builder
+		GenerateLatexFromAST(doc, &sb)
+		if got := sb.String(); !strings.Contains(got, test.expected) {
+			t.Errorf("GenerateLatexFromAST(%q) = %q, want it to contain %q", test.markdown, got, test.expected)
+		}
+	}
+}
+
 // Mock AST node for testing
 type mockNode struct {
 	ast.Node
@@ -15,4 +15,9 @@ var (
 	// Streaming processing patterns - used to find the start and end of delimiters
 	StartDisplayMath   = regexp.MustCompile(`(?:^|\s)\$\$|\\\[`)
 	EndDisplayMath     = regexp.MustCompile(`\$\$(?:$|\s)|\\\]`)
+
+	// Bare math environments - \begin{align} etc. with no $$ around them,
+	// and any \begin or \end to track how deeply they are nested
+	BeginMathEnv       = regexp.MustCompile(`\\begin\{(?:equation|align|alignat|gather|multline|flalign)\*?\}`)
+	EnvDelimiter       = regexp.MustCompile(`\\(begin|end)\{[^{}]*\}`)
 )
\ No newline at end of file
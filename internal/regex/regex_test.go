@@ -165,4 +165,27 @@ func TestStreamProcessing(t *testing.T) {
 			t.Errorf("EndDisplayMath.MatchString(%q): got match = %v, want %v", test.input, found, test.expected)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestBeginMathEnv(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"\\begin{align}", true},
+		{"Text \\begin{equation*} x", true},
+		{"\\begin{gather}", true},
+		{"\\begin{alignat}{2}", true},
+		{"\\begin{aligned}", false},
+		{"\\begin{itemize}", false},
+		{"\\end{align}", false},
+		{"No math here", false},
+	}
+
+	for _, test := range tests {
+		found := BeginMathEnv.MatchString(test.input)
+		if found != test.expected {
+			t.Errorf("BeginMathEnv.MatchString(%q): got match = %v, want %v", test.input, found, test.expected)
+		}
+	}
+}
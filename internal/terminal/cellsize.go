@@ -0,0 +1,128 @@
+// Package terminal provides terminal-specific functionality for DML
+package terminal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// Adaptive DPI bounds and the fallback used when the cell size is unknown
+const (
+	MinAdaptiveDPI     = 96
+	MaxAdaptiveDPI     = 600
+	FallbackDPI        = 300
+	latexFontSizePt    = 10.0  // body font size of the LaTeX templates
+	latexPointsPerInch = 72.27 // TeX points per inch
+)
+
+// CellSize is the size in pixels of one terminal character cell
+type CellSize struct {
+	Width  int
+	Height int
+}
+
+// Valid reports whether both dimensions are known
+func (c CellSize) Valid() bool {
+	return c.Width > 0 && c.Height > 0
+}
+
+// Replies to CSI 16t (cell size) and CSI 14t (text area size), both in pixels
+var (
+	cellSizeReply   = regexp.MustCompile(`\x1b\[6;(\d+);(\d+)t`)
+	windowSizeReply = regexp.MustCompile(`\x1b\[4;(\d+);(\d+)t`)
+)
+
+// QueryCellSize determines the pixel size of a terminal cell. It first asks
+// the kernel (TIOCGWINSZ pixel fields), then falls back to the xterm CSI 16t
+// and CSI 14t window reports.
+func QueryCellSize() (CellSize, error) {
+	cols, rows, xpix, ypix, wsErr := windowSize()
+	if wsErr == nil && xpix > 0 && ypix > 0 && cols > 0 && rows > 0 {
+		cell := CellSize{Width: xpix / cols, Height: ypix / rows}
+		if isDebug {
+			fmt.Fprintf(os.Stderr, "DEBUG: Cell size from TIOCGWINSZ: %dx%d px\n", cell.Width, cell.Height)
+		}
+		return cell, nil
+	}
+
+	// CSI 16t reports the cell size directly
+	if reply, err := queryTTY("\x1b[16t", replyMatches(cellSizeReply)); err == nil {
+		if m := cellSizeReply.FindSubmatch(reply); m != nil {
+			cell := CellSize{Height: atoi(m[1]), Width: atoi(m[2])}
+			if cell.Valid() {
+				if isDebug {
+					fmt.Fprintf(os.Stderr, "DEBUG: Cell size from CSI 16t: %dx%d px\n", cell.Width, cell.Height)
+				}
+				return cell, nil
+			}
+		}
+	}
+
+	// CSI 14t reports the text area size, which we divide by the grid size
+	if wsErr == nil && cols > 0 && rows > 0 {
+		if reply, err := queryTTY("\x1b[14t", replyMatches(windowSizeReply)); err == nil {
+			if m := windowSizeReply.FindSubmatch(reply); m != nil {
+				cell := CellSize{Height: atoi(m[1]) / rows, Width: atoi(m[2]) / cols}
+				if cell.Valid() {
+					if isDebug {
+						fmt.Fprintf(os.Stderr, "DEBUG: Cell size from CSI 14t: %dx%d px\n", cell.Width, cell.Height)
+					}
+					return cell, nil
+				}
+			}
+		}
+	}
+
+	return CellSize{}, fmt.Errorf("terminal did not report its cell size")
+}
+
+// The cell size images are laid out with, set once before rendering starts
+var (
+	cellSize    CellSize
+	cellSizeErr = errors.New("terminal cell size was not queried")
+)
+
+// SetCellSize records the result of QueryCellSize for CachedCellSize. The
+// terminal is queried once, before images are rendered in parallel, as
+// replies to concurrent queries would be mixed up.
+func SetCellSize(cell CellSize, err error) {
+	cellSize, cellSizeErr = cell, err
+}
+
+// CachedCellSize returns the cell size recorded with SetCellSize
+func CachedCellSize() (CellSize, error) {
+	return cellSize, cellSizeErr
+}
+
+// AdaptiveDPI chooses a rendering DPI at which the LaTeX body font is as tall
+// as a terminal cell, clamped to [MinAdaptiveDPI, MaxAdaptiveDPI]
+func AdaptiveDPI(cell CellSize) int {
+	if cell.Height <= 0 {
+		return FallbackDPI
+	}
+	dpi := int(math.Round(float64(cell.Height) * latexPointsPerInch / latexFontSizePt))
+	if dpi < MinAdaptiveDPI {
+		dpi = MinAdaptiveDPI
+	}
+	if dpi > MaxAdaptiveDPI {
+		dpi = MaxAdaptiveDPI
+	}
+	return dpi
+}
+
+// replyMatches returns a completion test for queryTTY that waits for pattern
+func replyMatches(pattern *regexp.Regexp) func([]byte) bool {
+	return func(reply []byte) bool {
+		return pattern.Match(reply)
+	}
+}
+
+func atoi(b []byte) int {
+	n, _ := strconv.Atoi(string(bytes.TrimSpace(b)))
+	return n
+}
@@ -0,0 +1,51 @@
+package terminal
+
+import (
+	"testing"
+)
+
+func TestAdaptiveDPI(t *testing.T) {
+	tests := []struct {
+		name string
+		cell CellSize
+		want int
+	}{
+		{"Unknown cell size", CellSize{}, FallbackDPI},
+		{"Typical 20px cells", CellSize{Width: 10, Height: 20}, 145},
+		{"HiDPI 40px cells", CellSize{Width: 20, Height: 40}, 289},
+		{"Tiny cells clamp to minimum", CellSize{Width: 5, Height: 8}, MinAdaptiveDPI},
+		{"Huge cells clamp to maximum", CellSize{Width: 60, Height: 120}, MaxAdaptiveDPI},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := AdaptiveDPI(test.cell); got != test.want {
+				t.Errorf("AdaptiveDPI(%+v) = %d, want %d", test.cell, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCellSizeReplies(t *testing.T) {
+	m := cellSizeReply.FindSubmatch([]byte("\x1b[6;18;9t"))
+	if m == nil || atoi(m[1]) != 18 || atoi(m[2]) != 9 {
+		t.Errorf("Failed to parse CSI 16t reply: %q", m)
+	}
+	m = windowSizeReply.FindSubmatch([]byte("junk\x1b[4;720;1280t"))
+	if m == nil || atoi(m[1]) != 720 || atoi(m[2]) != 1280 {
+		t.Errorf("Failed to parse CSI 14t reply: %q", m)
+	}
+	if replyMatches(cellSizeReply)([]byte("\x1b[6;18")) {
+		t.Errorf("Partial reply should not be treated as complete")
+	}
+}
+
+func TestSetCellSize(t *testing.T) {
+	defer SetCellSize(CachedCellSize())
+
+	// Renders see what the main goroutine recorded, without asking the terminal
+	SetCellSize(CellSize{Width: 9, Height: 18}, nil)
+	if cell, err := CachedCellSize(); err != nil || cell != (CellSize{Width: 9, Height: 18}) {
+		t.Errorf("CachedCellSize() = %+v, %v, want 9x18", cell, err)
+	}
+}
@@ -0,0 +1,78 @@
+// Package terminal provides terminal-specific functionality for DML
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// The detection queries: a Kitty graphics query for a 1x1 image, which is
+// checked but never stored, then DA1, which every terminal answers. The
+// reply is complete once the DA1 reply arrives, and terminals with the Kitty
+// protocol answer the graphics query before it.
+const (
+	kittyGraphicsQuery = "\x1b_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\x1b\\"
+	primaryDAQuery     = "\x1b[c"
+)
+
+// Replies to the Kitty graphics query and DA1, whose attribute 4 is Sixel
+var (
+	kittyGraphicsReply = regexp.MustCompile(`\x1b_Gi=31;OK\x1b\\`)
+	primaryDAReply     = regexp.MustCompile(`\x1b\[\?([0-9;]*)c`)
+)
+
+// DetectProtocol picks the graphics protocol to write images in. Output
+// that isn't going to a terminal gets ProtocolText. Otherwise the terminal
+// is asked whether it supports the Kitty protocol and, with DA1, Sixel, and
+// $TERM_PROGRAM and $TERM identify terminals that don't answer.
+func DetectProtocol() Protocol {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		if isDebug {
+			fmt.Fprintln(os.Stderr, "DEBUG: Standard output is not a terminal; writing math as text")
+		}
+		return ProtocolText
+	}
+
+	// A terminal that doesn't answer at all may still be known by name
+	reply, err := queryTTY(Passthrough(kittyGraphicsQuery)+primaryDAQuery, replyMatches(primaryDAReply))
+	if err != nil && isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Graphics protocol query failed: %v\n", err)
+	}
+
+	p := chooseProtocol(reply, os.Getenv("TERM_PROGRAM"), os.Getenv("TERM"))
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Detected graphics protocol: %s (TERM_PROGRAM=%q, TERM=%q)\n", p, os.Getenv("TERM_PROGRAM"), os.Getenv("TERM"))
+	}
+	return p
+}
+
+// chooseProtocol picks the best graphics protocol given the terminal's reply
+// to the detection queries and the values of $TERM_PROGRAM and $TERM
+func chooseProtocol(reply []byte, termProgram, termName string) Protocol {
+	if kittyGraphicsReply.Match(reply) {
+		return ProtocolKitty
+	}
+	switch termProgram {
+	case "iTerm.app", "WezTerm":
+		return ProtocolIterm
+	}
+	if m := primaryDAReply.FindSubmatch(reply); m != nil {
+		for _, attr := range strings.Split(string(m[1]), ";") {
+			if attr == "4" {
+				return ProtocolSixel
+			}
+		}
+	}
+
+	switch {
+	case termName == "xterm-kitty", termName == "xterm-ghostty", termProgram == "ghostty":
+		return ProtocolKitty
+	case strings.HasPrefix(termName, "foot"), strings.HasPrefix(termName, "mlterm"):
+		return ProtocolSixel
+	}
+	return ProtocolText
+}
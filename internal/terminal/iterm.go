@@ -0,0 +1,75 @@
+// Package terminal provides terminal-specific functionality for DML
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"os"
+)
+
+// ItermInline generates the iTerm2 inline image protocol (OSC 1337) string
+// for the given image bytes. Images are sized in rows like KittyInline:
+// userTargetRows if set, otherwise one cell high for inline math and their
+// natural size for display math, always keeping the aspect ratio.
+func ItermInline(img []byte, isDisplayMath bool, userTargetRows int) (string, error) {
+	if _, err := png.DecodeConfig(bytes.NewReader(img)); err != nil {
+		return "", fmt.Errorf("decoding math PNG: %v", err)
+	}
+
+	rows := userTargetRows
+	if rows <= 0 && !isDisplayMath {
+		rows = 1
+	}
+	itermStr := itermImage(img, rows)
+
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Generated iTerm2 image: %d bytes, rows=%d, isDisplay=%v\n", len(img), rows, isDisplayMath)
+	}
+
+	if isDisplayMath {
+		return itermStr + "\n", nil
+	}
+	return itermStr + " ", nil
+}
+
+// ItermInlineAligned generates the iTerm2 inline image string for an inline
+// math image whose baseline lies depth pixels above its bottom edge, padded
+// so that scaled to one row its baseline lands on the text baseline as with
+// KittyInlineAligned
+func ItermInlineAligned(img []byte, depth int, cell CellSize) (string, error) {
+	if !cell.Valid() {
+		return "", fmt.Errorf("cell size unknown")
+	}
+	src, err := png.Decode(bytes.NewReader(img))
+	if err != nil {
+		return "", fmt.Errorf("decoding inline math PNG: %v", err)
+	}
+	height := src.Bounds().Dy()
+	if depth < 0 || depth > height {
+		return "", fmt.Errorf("baseline depth %d outside image of height %d", depth, height)
+	}
+
+	canvas := baselineCanvas(src, depth, cell)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return "", fmt.Errorf("encoding padded inline math PNG: %v", err)
+	}
+
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Aligned inline iTerm2 math: %dx%d px padded to %d px, depth %d, cell %dx%d\n",
+			src.Bounds().Dx(), height, canvas.Bounds().Dy(), depth, cell.Width, cell.Height)
+	}
+	return itermImage(buf.Bytes(), 1) + " ", nil
+}
+
+// itermImage returns the OSC 1337 sequence that draws a PNG inline, rows
+// cells high if rows is positive or at its natural size otherwise
+func itermImage(img []byte, rows int) string {
+	args := fmt.Sprintf("inline=1;size=%d;preserveAspectRatio=1", len(img))
+	if rows > 0 {
+		args += fmt.Sprintf(";height=%d", rows)
+	}
+	return "\x1b]1337;File=" + args + ":" + base64.StdEncoding.EncodeToString(img) + "\a"
+}
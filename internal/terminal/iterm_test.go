@@ -0,0 +1,78 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestItermInline(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 6))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	tests := []struct {
+		name          string
+		isDisplayMath bool
+		targetRows    int
+		args          string
+		suffix        string
+	}{
+		{"Inline math is one cell high", false, 0, ";height=1", " "},
+		{"Display math at natural size", true, 0, "", "\n"},
+		{"Custom height (3 rows)", true, 3, ";height=3", "\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ItermInline(buf.Bytes(), test.isDisplayMath, test.targetRows)
+			if err != nil {
+				t.Fatalf("ItermInline: %v", err)
+			}
+			want := "\x1b]1337;File=inline=1;size=" + strconv.Itoa(buf.Len()) + ";preserveAspectRatio=1" + test.args + ":" + data + "\a" + test.suffix
+			if got != want {
+				t.Errorf("ItermInline = %q, want %q", got, want)
+			}
+		})
+	}
+
+	if _, err := ItermInline([]byte{}, false, 0); err == nil {
+		t.Errorf("Expected an error for an empty image")
+	}
+}
+
+func TestItermInlineAligned(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 10))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ItermInlineAligned(buf.Bytes(), 2, CellSize{Width: 8, Height: 20})
+	if err != nil {
+		t.Fatalf("ItermInlineAligned: %v", err)
+	}
+	if !strings.HasPrefix(got, "\x1b]1337;File=inline=1;") || !strings.Contains(got, ";height=1:") {
+		t.Fatalf("Expected a one row iTerm2 image, got %q", got)
+	}
+
+	// The image is padded to a full cell, with the baseline at the text baseline
+	encoded := got[strings.Index(got, ":")+1 : strings.Index(got, "\a")]
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("Decoding image data: %v", err)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(decoded))
+	if err != nil {
+		t.Fatalf("Decoding padded PNG: %v", err)
+	}
+	if cfg.Width != 8 || cfg.Height != 20 {
+		t.Errorf("Padded image is %dx%d, want 8x20", cfg.Width, cfg.Height)
+	}
+}
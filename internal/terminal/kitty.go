@@ -4,6 +4,10 @@ package terminal
 import (
 	"bytes"
 	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
 
 	// "io"
 	"os"
@@ -12,6 +16,15 @@ import (
 	"github.com/BourgeoisBear/rasterm"
 )
 
+// textDescentRatio is the fraction of a cell below the text baseline. Terminals
+// don't report font metrics, but monospace fonts put roughly a fifth of the
+// line height below the baseline.
+const textDescentRatio = 0.2
+
+// inlineSuffix follows every inline image; the invisible mark keeps the
+// terminal from dropping the character after the image
+const inlineSuffix = "‎ "
+
 var isDebug bool
 
 // SetDebug enables or disables debug mode
@@ -57,7 +70,7 @@ func KittyInline(img []byte, isDisplayMath bool, userTargetRows int) (string, er
 		kittyStr = strings.TrimRight(kittyStr, "\n") + "\n"
 	} else {
 		// For inline math, ensure there are no trailing newlines
-		kittyStr = strings.TrimRight(kittyStr, "\n") + "‎ "
+		kittyStr = strings.TrimRight(kittyStr, "\n") + inlineSuffix
 	} // Add invisible character afterwards to avoid dropped characters
 
 	// Remove null characters that might appear
@@ -65,3 +78,86 @@ func KittyInline(img []byte, isDisplayMath bool, userTargetRows int) (string, er
 
 	return kittyStr, nil
 }
+
+// KittyInlineAligned generates the Kitty graphics protocol string for an
+// inline math image whose baseline lies depth pixels above its bottom edge.
+// The image is placed on the current row so that its baseline lands on the
+// text baseline: if it fits in the cell at its natural size it is shifted
+// down with a sub-cell Y offset, otherwise it is padded so the baseline sits
+// at the same relative height and scaled down to one row.
+func KittyInlineAligned(img []byte, depth int, cell CellSize) (string, error) {
+	if !cell.Valid() {
+		return "", fmt.Errorf("cell size unknown")
+	}
+	src, err := png.Decode(bytes.NewReader(img))
+	if err != nil {
+		return "", fmt.Errorf("decoding inline math PNG: %v", err)
+	}
+	bounds := src.Bounds()
+	height := bounds.Dy()
+	if depth < 0 || depth > height {
+		return "", fmt.Errorf("baseline depth %d outside image of height %d", depth, height)
+	}
+
+	below := int(math.Round(float64(cell.Height) * textDescentRatio))
+	above := cell.Height - below
+	ascent := height - depth
+
+	opts := rasterm.KittyImgOpts{}
+	data := img
+	if ascent <= above && depth <= below {
+		// Natural size: just drop the image to the baseline within the cell
+		opts.CellOffsetY = uint32(above - ascent)
+	} else {
+		// Pad to a canvas whose baseline sits at the text baseline ratio,
+		// then let Kitty scale that canvas to exactly one row
+		canvas := baselineCanvas(src, depth, cell)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, canvas); err != nil {
+			return "", fmt.Errorf("encoding padded inline math PNG: %v", err)
+		}
+		data = buf.Bytes()
+		opts.DstRows = 1
+	}
+
+	var sb strings.Builder
+	if err := rasterm.KittyCopyPNGInline(&sb, bytes.NewReader(data), opts); err != nil {
+		return "", fmt.Errorf("rasterm.KittyCopyPNGInline failed: %v", err)
+	}
+
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Aligned inline math: %dx%d px, depth %d, cell %dx%d, offsetY=%d, rows=%d\n",
+			bounds.Dx(), height, depth, cell.Width, cell.Height, opts.CellOffsetY, opts.DstRows)
+	}
+
+	kittyStr := strings.TrimRight(sb.String(), "\n") + inlineSuffix
+	return strings.ReplaceAll(kittyStr, "\x00", ""), nil
+}
+
+// baselineCanvas pads src, whose baseline lies depth pixels above its bottom
+// edge, onto a transparent canvas at least a cell tall whose baseline divides
+// it in the same ratio as the text baseline divides a cell. Scaled to one
+// row, the canvas puts the image's baseline on the text baseline.
+func baselineCanvas(src image.Image, depth int, cell CellSize) *image.NRGBA {
+	bounds := src.Bounds()
+	height := bounds.Dy()
+	below := int(math.Round(float64(cell.Height) * textDescentRatio))
+	above := cell.Height - below
+	ascent := height - depth
+
+	canvasHeight := math.Max(float64(cell.Height),
+		math.Max(float64(ascent)*float64(cell.Height)/float64(above),
+			float64(depth)*float64(cell.Height)/float64(below)))
+	padded := int(math.Ceil(canvasHeight))
+	top := int(math.Round(float64(padded)*float64(above)/float64(cell.Height))) - ascent
+	if top < 0 {
+		top = 0
+	}
+	if top+height > padded {
+		padded = top + height
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), padded))
+	draw.Draw(canvas, image.Rect(0, top, bounds.Dx(), top+height), src, bounds.Min, draw.Src)
+	return canvas
+}
@@ -1,6 +1,9 @@
 package terminal
 
 import (
+	"bytes"
+	"image"
+	"image/png"
 	"strings"
 	"testing"
 )
@@ -120,4 +123,95 @@ func TestNullCharacterHandling(t *testing.T) {
 	if strings.Contains(result, "\x00") {
 		t.Errorf("Output contains null characters which should have been removed")
 	}
-}
\ No newline at end of file
+}
+
+func TestKittyInlineAligned(t *testing.T) {
+	makePNG := func(w, h int) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+			t.Fatalf("png.Encode failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+	cell := CellSize{Width: 10, Height: 20}
+
+	tests := []struct {
+		name        string
+		img         []byte
+		depth       int
+		cell        CellSize
+		expectError bool
+		contains    []string
+		excludes    []string
+	}{
+		{
+			name:     "Fits in cell uses Y offset at natural size",
+			img:      makePNG(12, 14),
+			depth:    2,
+			cell:     cell,
+			contains: []string{"Y=4"}, // baseline 16px from top of cell, 12px ascent
+			excludes: []string{"r=1"},
+		},
+		{
+			name:     "Sitting exactly on the top of the cell needs no offset",
+			img:      makePNG(12, 16),
+			depth:    0,
+			cell:     cell,
+			excludes: []string{"Y=", "r=1"},
+		},
+		{
+			name:     "Tall image is padded and scaled to one row",
+			img:      makePNG(30, 60),
+			depth:    20,
+			cell:     cell,
+			contains: []string{"r=1"},
+			excludes: []string{"Y="},
+		},
+		{
+			name:        "Unknown cell size",
+			img:         makePNG(4, 4),
+			depth:       1,
+			cell:        CellSize{},
+			expectError: true,
+		},
+		{
+			name:        "Depth outside image",
+			img:         makePNG(4, 4),
+			depth:       9,
+			cell:        cell,
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := KittyInlineAligned(test.img, test.depth, test.cell)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(result, "\x1b_G") {
+				t.Errorf("Output doesn't start with Kitty protocol escape sequence. Got: %q", result)
+			}
+			if strings.Contains(result, "\n") {
+				t.Errorf("Inline output should not contain newlines. Got: %q", result)
+			}
+			header := result[:strings.Index(result, ";")]
+			for _, want := range test.contains {
+				if !strings.Contains(header, want) {
+					t.Errorf("Header %q missing %q", header, want)
+				}
+			}
+			for _, unwanted := range test.excludes {
+				if strings.Contains(header, unwanted) {
+					t.Errorf("Header %q should not contain %q", header, unwanted)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Package terminal provides terminal-specific functionality for DML
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// multiplexer is a terminal multiplexer that DML's output goes through
+type multiplexer int
+
+const (
+	noMultiplexer multiplexer = iota
+	tmuxMultiplexer
+	screenMultiplexer
+)
+
+// Passthrough escapes are split into pieces no longer than these. tmux
+// buffers whole escapes, and GNU screen drops strings over 768 bytes.
+const (
+	tmuxPassthroughChunk   = 4096
+	screenPassthroughChunk = 512
+)
+
+// graphicsEscape matches the escapes that draw images: Kitty APCs, Sixel
+// DCSs and iTerm2 OSC 1337s. Multiplexers swallow these, whereas cursor
+// movement and text are theirs to handle and are left alone.
+var graphicsEscape = regexp.MustCompile(`(?s)\x1b_G.*?\x1b\\|\x1bP[0-9;]*q.*?\x1b\\|\x1b\]1337;.*?(?:\x07|\x1b\\)`)
+
+var (
+	multiplexerOnce sync.Once
+	currentMux      multiplexer
+)
+
+// detectMultiplexer reports whether DML runs inside tmux ($TMUX) or GNU
+// screen ($STY)
+func detectMultiplexer() multiplexer {
+	multiplexerOnce.Do(func() {
+		switch {
+		case os.Getenv("TMUX") != "":
+			currentMux = tmuxMultiplexer
+		case os.Getenv("STY") != "":
+			currentMux = screenMultiplexer
+		}
+		if isDebug && currentMux != noMultiplexer {
+			fmt.Fprintf(os.Stderr, "DEBUG: Running inside %s; wrapping graphics in passthrough escapes\n", currentMux)
+		}
+	})
+	return currentMux
+}
+
+func (m multiplexer) String() string {
+	switch m {
+	case tmuxMultiplexer:
+		return "tmux"
+	case screenMultiplexer:
+		return "screen"
+	default:
+		return "no multiplexer"
+	}
+}
+
+// Passthrough wraps every graphics escape in s in the DCS passthrough
+// envelope of the terminal multiplexer DML runs in, if any, so that the
+// multiplexer hands it on to the terminal instead of dropping it
+func Passthrough(s string) string {
+	return passthrough(s, detectMultiplexer())
+}
+
+// passthrough wraps the graphics escapes in s for the multiplexer m
+func passthrough(s string, m multiplexer) string {
+	switch m {
+	case tmuxMultiplexer:
+		return graphicsEscape.ReplaceAllStringFunc(s, func(seq string) string {
+			// ESCs inside the envelope are doubled
+			return wrapPieces(seq, tmuxPassthroughChunk, func(piece string) string {
+				return "\x1bPtmux;" + strings.ReplaceAll(piece, "\x1b", "\x1b\x1b") + "\x1b\\"
+			})
+		})
+	case screenMultiplexer:
+		return graphicsEscape.ReplaceAllStringFunc(s, func(seq string) string {
+			// screen ends its DCS at the first ST, and ESCs inside aren't
+			// escaped, so every ST in seq is cut in two: its ESC ends one
+			// piece and its backslash starts the next
+			parts := strings.Split(seq, "\x1b\\")
+			var sb strings.Builder
+			for i, part := range parts {
+				if i > 0 {
+					part = "\\" + part
+				}
+				if i < len(parts)-1 {
+					part += "\x1b"
+				}
+				if part == "" {
+					continue
+				}
+				sb.WriteString(wrapPieces(part, screenPassthroughChunk, func(piece string) string {
+					return "\x1bP" + piece + "\x1b\\"
+				}))
+			}
+			return sb.String()
+		})
+	default:
+		return s
+	}
+}
+
+// wrapPieces splits seq into pieces of at most size bytes and wraps each
+// one. The multiplexer writes the pieces out back to back, so the terminal
+// sees the escape whole.
+func wrapPieces(seq string, size int, wrap func(string) string) string {
+	var sb strings.Builder
+	for len(seq) > size {
+		sb.WriteString(wrap(seq[:size]))
+		seq = seq[size:]
+	}
+	sb.WriteString(wrap(seq))
+	return sb.String()
+}
+
+// TmuxPassthroughHint returns advice for the user if DML runs in tmux with
+// allow-passthrough off, so that every image would be dropped, or ""
+// otherwise. tmux before 3.3 has no such option and always passes escapes
+// through.
+func TmuxPassthroughHint() string {
+	if detectMultiplexer() != tmuxMultiplexer {
+		return ""
+	}
+	out, err := exec.Command("tmux", "show-options", "-gv", "allow-passthrough").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "off" {
+		return ""
+	}
+	return "tmux's allow-passthrough option is off, so images can't reach the terminal. Turn it on with: tmux set -g allow-passthrough on"
+}
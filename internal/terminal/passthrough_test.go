@@ -0,0 +1,73 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPassthrough(t *testing.T) {
+	apc := "\x1b_Ga=T,f=100;AAAA\x1b\\"
+	s := "text " + apc + "\x1b[2C more"
+
+	if got := passthrough(s, noMultiplexer); got != s {
+		t.Errorf("passthrough outside a multiplexer = %q, want it unchanged", got)
+	}
+
+	want := "text \x1bPtmux;\x1b\x1b_Ga=T,f=100;AAAA\x1b\x1b\\\x1b\\\x1b[2C more"
+	if got := passthrough(s, tmuxMultiplexer); got != want {
+		t.Errorf("passthrough for tmux = %q, want %q", got, want)
+	}
+
+	// The APC's own ST would end screen's DCS, so it is split between two
+	want = "text \x1bP\x1b_Ga=T,f=100;AAAA\x1b\x1b\\\x1bP\\\x1b\\\x1b[2C more"
+	if got := passthrough(s, screenMultiplexer); got != want {
+		t.Errorf("passthrough for screen = %q, want %q", got, want)
+	}
+
+	// Sixel and iTerm2 images are wrapped too
+	for _, seq := range []string{"\x1bP0;1;0q\"1;1;1;1#0@\x1b\\", "\x1b]1337;File=inline=1:AAAA\a"} {
+		if got := passthrough(seq, tmuxMultiplexer); !strings.HasPrefix(got, "\x1bPtmux;\x1b\x1b") {
+			t.Errorf("passthrough for tmux of %q = %q, want it wrapped", seq, got)
+		}
+	}
+}
+
+func TestPassthroughPieces(t *testing.T) {
+	seq := "\x1b_Ga=T,m=1;" + strings.Repeat("A", 1200) + "\x1b\\\x1b_Gm=0;AAAA\x1b\\"
+	pieces := unwrapScreen(t, passthrough(seq, screenMultiplexer))
+
+	// Three pieces for the 1212 bytes up to the first ST's ESC and one for
+	// its backslash, then two for the second escape
+	if len(pieces) != 6 {
+		t.Errorf("Expected 6 screen pieces, got %d: %q", len(pieces), pieces)
+	}
+	for _, piece := range pieces {
+		if len(piece) > screenPassthroughChunk {
+			t.Errorf("Piece of %d bytes is over screen's limit", len(piece))
+		}
+	}
+	if joined := strings.Join(pieces, ""); joined != seq {
+		t.Errorf("Pieces join into %q, want the original escapes", joined)
+	}
+}
+
+// unwrapScreen reads s as GNU screen does: a DCS passthrough runs from
+// ESC P to the first ST, and an ESC inside it that isn't followed by a
+// backslash is passed on. It returns what each envelope passes on.
+func unwrapScreen(t *testing.T, s string) []string {
+	var pieces []string
+	for s != "" {
+		if !strings.HasPrefix(s, "\x1bP") {
+			t.Fatalf("Expected a DCS envelope at %q", s)
+		}
+		i := 2
+		for ; i < len(s) && !strings.HasPrefix(s[i:], "\x1b\\"); i++ {
+		}
+		if i == len(s) {
+			t.Fatalf("Unterminated envelope %q", s)
+		}
+		pieces = append(pieces, s[2:i])
+		s = s[i+2:]
+	}
+	return pieces
+}
@@ -0,0 +1,222 @@
+// Package terminal provides terminal-specific functionality for DML
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"image/png"
+	"os"
+	"strings"
+	"sync"
+)
+
+// placeholder is the character Kitty replaces with a cell of an image
+const placeholder = "\U0010EEEE"
+
+// kittyChunkSize is the most base64 data Kitty accepts in one escape
+const kittyChunkSize = 4096
+
+// placeholderDiacritics are the combining characters that number the rows
+// and columns of placeholder cells, from Kitty's rowcolumn-diacritics.txt
+var placeholderDiacritics = []rune{
+	0x0305, 0x030D, 0x030E, 0x0310, 0x0312, 0x033D, 0x033E, 0x033F, 0x0346, 0x034A, 0x034B, 0x034C,
+	0x0350, 0x0351, 0x0352, 0x0357, 0x035B, 0x0363, 0x0364, 0x0365, 0x0366, 0x0367, 0x0368, 0x0369,
+	0x036A, 0x036B, 0x036C, 0x036D, 0x036E, 0x036F, 0x0483, 0x0484, 0x0485, 0x0486, 0x0487, 0x0592,
+	0x0593, 0x0594, 0x0595, 0x0597, 0x0598, 0x0599, 0x059C, 0x059D, 0x059E, 0x059F, 0x05A0, 0x05A1,
+	0x05A8, 0x05A9, 0x05AB, 0x05AC, 0x05AF, 0x05C4, 0x0610, 0x0611, 0x0612, 0x0613, 0x0614, 0x0615,
+	0x0616, 0x0617, 0x0657, 0x0658, 0x0659, 0x065A, 0x065B, 0x065D, 0x065E, 0x06D6, 0x06D7, 0x06D8,
+	0x06D9, 0x06DA, 0x06DB, 0x06DC, 0x06DF, 0x06E0, 0x06E1, 0x06E2, 0x06E4, 0x06E7, 0x06E8, 0x06EB,
+	0x06EC, 0x0730, 0x0732, 0x0733, 0x0735, 0x0736, 0x073A, 0x073D, 0x073F, 0x0740, 0x0741, 0x0743,
+	0x0745, 0x0747, 0x0749, 0x074A, 0x07EB, 0x07EC, 0x07ED, 0x07EE, 0x07EF, 0x07F0, 0x07F1, 0x07F3,
+	0x0816, 0x0817, 0x0818, 0x0819, 0x081B, 0x081C, 0x081D, 0x081E, 0x081F, 0x0820, 0x0821, 0x0822,
+	0x0823, 0x0825, 0x0826, 0x0827, 0x0829, 0x082A, 0x082B, 0x082C, 0x082D, 0x0951, 0x0953, 0x0954,
+	0x0F82, 0x0F83, 0x0F86, 0x0F87, 0x135D, 0x135E, 0x135F, 0x17DD, 0x193A, 0x1A17, 0x1A75, 0x1A76,
+	0x1A77, 0x1A78, 0x1A79, 0x1A7A, 0x1A7B, 0x1A7C, 0x1B6B, 0x1B6D, 0x1B6E, 0x1B6F, 0x1B70, 0x1B71,
+	0x1B72, 0x1B73, 0x1CD0, 0x1CD1, 0x1CD2, 0x1CDA, 0x1CDB, 0x1CE0, 0x1DC0, 0x1DC1, 0x1DC3, 0x1DC4,
+	0x1DC5, 0x1DC6, 0x1DC7, 0x1DC8, 0x1DC9, 0x1DCB, 0x1DCC, 0x1DD1, 0x1DD2, 0x1DD3, 0x1DD4, 0x1DD5,
+	0x1DD6, 0x1DD7, 0x1DD8, 0x1DD9, 0x1DDA, 0x1DDB, 0x1DDC, 0x1DDD, 0x1DDE, 0x1DDF, 0x1DE0, 0x1DE1,
+	0x1DE2, 0x1DE3, 0x1DE4, 0x1DE5, 0x1DE6, 0x1DFE, 0x20D0, 0x20D1, 0x20D4, 0x20D5, 0x20D6, 0x20D7,
+	0x20DB, 0x20DC, 0x20E1, 0x20E7, 0x20E9, 0x20F0, 0x2CEF, 0x2CF0, 0x2CF1, 0x2DE0, 0x2DE1, 0x2DE2,
+	0x2DE3, 0x2DE4, 0x2DE5, 0x2DE6, 0x2DE7, 0x2DE8, 0x2DE9, 0x2DEA, 0x2DEB, 0x2DEC, 0x2DED, 0x2DEE,
+	0x2DEF, 0x2DF0, 0x2DF1, 0x2DF2, 0x2DF3, 0x2DF4, 0x2DF5, 0x2DF6, 0x2DF7, 0x2DF8, 0x2DF9, 0x2DFA,
+	0x2DFB, 0x2DFC, 0x2DFD, 0x2DFE, 0x2DFF, 0xA66F, 0xA67C, 0xA67D, 0xA6F0, 0xA6F1, 0xA8E0, 0xA8E1,
+	0xA8E2, 0xA8E3, 0xA8E4, 0xA8E5, 0xA8E6, 0xA8E7, 0xA8E8, 0xA8E9, 0xA8EA, 0xA8EB, 0xA8EC, 0xA8ED,
+	0xA8EE, 0xA8EF, 0xA8F0, 0xA8F1, 0xAAB0, 0xAAB2, 0xAAB3, 0xAAB7, 0xAAB8, 0xAABE, 0xAABF, 0xAAC1,
+	0xFE20, 0xFE21, 0xFE22, 0xFE23, 0xFE24, 0xFE25, 0xFE26, 0x10A0F, 0x10A38, 0x1D185, 0x1D186, 0x1D187,
+	0x1D188, 0x1D189, 0x1D1AA, 0x1D1AB, 0x1D1AC, 0x1D1AD, 0x1D242, 0x1D243, 0x1D244,
+}
+
+// usePlaceholders makes Inline and InlineAligned write Kitty images as
+// Unicode placeholders
+var usePlaceholders bool
+
+// SetKittyPlaceholders enables or disables Unicode placeholders for the
+// Kitty protocol
+func SetKittyPlaceholders(enabled bool) {
+	usePlaceholders = enabled
+}
+
+// Images already sent to the terminal this run, by image ID
+var (
+	transmittedMu sync.Mutex
+	transmitted   = make(map[uint32]bool)
+)
+
+// KittyPlaceholders generates the Kitty graphics string for the given image
+// bytes as Unicode placeholders: the image is transmitted once with a virtual
+// placement (U=1), then drawn as ordinary text cells that terminal
+// multiplexers and scrollback keep track of. It is sized like KittyInline,
+// but the rows and columns have to be worked out here, so the cell size must
+// be known.
+func KittyPlaceholders(img []byte, isDisplayMath bool, userTargetRows int) (string, error) {
+	cfg, err := png.DecodeConfig(bytes.NewReader(img))
+	if err != nil {
+		return "", fmt.Errorf("decoding math PNG: %v", err)
+	}
+	cell, err := CachedCellSize()
+	if err != nil {
+		return "", fmt.Errorf("sizing Kitty placeholders: %v", err)
+	}
+
+	rows := userTargetRows
+	if rows <= 0 {
+		rows = 1
+		if isDisplayMath {
+			rows = (cfg.Height + cell.Height - 1) / cell.Height
+		}
+	}
+	cols := placeholderColumns(cfg.Width, cfg.Height, rows, cell)
+
+	kittyStr := transmitVirtual(img, rows, cols) + placeholderCells(imageID(img, rows, cols), rows, cols)
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Generated Kitty placeholders: %dx%d cells, isDisplay=%v\n", cols, rows, isDisplayMath)
+	}
+	if isDisplayMath {
+		return kittyStr + "\n", nil
+	}
+	return kittyStr + " ", nil
+}
+
+// KittyPlaceholdersAligned is KittyPlaceholders for an inline math image
+// whose baseline lies depth pixels above its bottom edge. Placeholder cells
+// can't be offset within a cell, so the image is padded as by
+// KittyInlineAligned and scaled to one row.
+func KittyPlaceholdersAligned(img []byte, depth int, cell CellSize) (string, error) {
+	if !cell.Valid() {
+		return "", fmt.Errorf("cell size unknown")
+	}
+	src, err := png.Decode(bytes.NewReader(img))
+	if err != nil {
+		return "", fmt.Errorf("decoding inline math PNG: %v", err)
+	}
+	if height := src.Bounds().Dy(); depth < 0 || depth > height {
+		return "", fmt.Errorf("baseline depth %d outside image of height %d", depth, height)
+	}
+
+	canvas := baselineCanvas(src, depth, cell)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return "", fmt.Errorf("encoding padded inline math PNG: %v", err)
+	}
+	data := buf.Bytes()
+	cols := placeholderColumns(canvas.Bounds().Dx(), canvas.Bounds().Dy(), 1, cell)
+
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Aligned inline Kitty placeholders: %d cells, depth %d, cell %dx%d\n",
+			cols, depth, cell.Width, cell.Height)
+	}
+	return transmitVirtual(data, 1, cols) + placeholderCells(imageID(data, 1, cols), 1, cols) + " ", nil
+}
+
+// placeholderColumns returns how many cells wide an image of the given size
+// is when scaled to rows rows
+func placeholderColumns(width, height, rows int, cell CellSize) int {
+	if height <= 0 {
+		return 1
+	}
+	scaledWidth := width * rows * cell.Height / height
+	cols := (scaledWidth + cell.Width - 1) / cell.Width
+	if cols < 1 {
+		cols = 1
+	}
+	return cols
+}
+
+// imageID derives the Kitty image ID from the image and its size, so the
+// same image always has the same ID and images from different runs of DML
+// don't replace each other. IDs are 24 bits, the most a foreground colour
+// can carry.
+func imageID(img []byte, rows, cols int) uint32 {
+	h := fnv.New32a()
+	h.Write(img)
+	fmt.Fprintf(h, "%dx%d", cols, rows)
+	id := h.Sum32() & 0xffffff
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
+
+// transmitVirtual returns the escapes that send img to the terminal with a
+// virtual placement rows x cols cells in size, or "" if it was already sent
+// this run. Responses are suppressed (q=2) so they don't end up as input.
+func transmitVirtual(img []byte, rows, cols int) string {
+	id := imageID(img, rows, cols)
+	transmittedMu.Lock()
+	sent := transmitted[id]
+	transmitted[id] = true
+	transmittedMu.Unlock()
+	if sent {
+		return ""
+	}
+
+	data := base64.StdEncoding.EncodeToString(img)
+	var sb strings.Builder
+	for first := true; first || len(data) > 0; first = false {
+		chunk := data
+		if len(chunk) > kittyChunkSize {
+			chunk = chunk[:kittyChunkSize]
+		}
+		data = data[len(chunk):]
+		more := 0
+		if len(data) > 0 {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&sb, "\x1b_Ga=T,U=1,f=100,q=2,i=%d,r=%d,c=%d,m=%d;%s\x1b\\", id, rows, cols, more, chunk)
+		} else {
+			fmt.Fprintf(&sb, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return sb.String()
+}
+
+// placeholderCells returns rows lines of cols placeholder cells for image
+// id, which is carried in the foreground colour. Each cell names its row and
+// column with diacritics, so images bigger than the diacritic table are cut
+// off. Lines are separated by newlines.
+func placeholderCells(id uint32, rows, cols int) string {
+	if rows > len(placeholderDiacritics) {
+		rows = len(placeholderDiacritics)
+	}
+	if cols > len(placeholderDiacritics) {
+		cols = len(placeholderDiacritics)
+	}
+
+	var sb strings.Builder
+	for row := 0; row < rows; row++ {
+		if row > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm", id>>16&0xff, id>>8&0xff, id&0xff)
+		for col := 0; col < cols; col++ {
+			sb.WriteString(placeholder)
+			sb.WriteRune(placeholderDiacritics[row])
+			sb.WriteRune(placeholderDiacritics[col])
+		}
+		sb.WriteString("\x1b[39m")
+	}
+	return sb.String()
+}
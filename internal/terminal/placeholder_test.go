@@ -0,0 +1,75 @@
+package terminal
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestPlaceholderDiacritics(t *testing.T) {
+	if len(placeholderDiacritics) != 297 {
+		t.Errorf("Diacritic table has %d entries, want 297", len(placeholderDiacritics))
+	}
+	for i := 1; i < len(placeholderDiacritics); i++ {
+		if placeholderDiacritics[i] <= placeholderDiacritics[i-1] {
+			t.Errorf("Diacritic %d (%U) out of order", i, placeholderDiacritics[i])
+		}
+	}
+}
+
+func TestPlaceholderCells(t *testing.T) {
+	got := placeholderCells(0x010203, 2, 2)
+	want := "\x1b[38;2;1;2;3m" + "\U0010EEEE̅̅" + "\U0010EEEE̅̍" + "\x1b[39m\n" +
+		"\x1b[38;2;1;2;3m" + "\U0010EEEE̍̅" + "\U0010EEEE̍̍" + "\x1b[39m"
+	if got != want {
+		t.Errorf("placeholderCells = %q, want %q", got, want)
+	}
+}
+
+func TestTransmitVirtual(t *testing.T) {
+	img := bytes.Repeat([]byte{'x'}, 4000) // 5336 bytes of base64
+	id := imageID(img, 2, 5)
+
+	got := transmitVirtual(img, 2, 5)
+	if !strings.HasPrefix(got, "\x1b_Ga=T,U=1,f=100,q=2,i=") || !strings.Contains(got, ",r=2,c=5,m=1;") {
+		t.Errorf("First chunk is %q, want a virtual placement with more to follow", got[:40])
+	}
+	if n := strings.Count(got, "\x1b_G"); n != 2 || !strings.Contains(got, "\x1b_Gm=0;") {
+		t.Errorf("Expected two chunks, the last with m=0, got %d", n)
+	}
+	if id == 0 || id > 0xffffff {
+		t.Errorf("imageID = %d, want a nonzero 24 bit ID", id)
+	}
+
+	// The image is only sent once
+	if again := transmitVirtual(img, 2, 5); again != "" {
+		t.Errorf("Expected no second transmission, got %d bytes", len(again))
+	}
+	if imageID(img, 1, 5) == id {
+		t.Errorf("Expected a different ID for a different size")
+	}
+}
+
+func TestKittyPlaceholdersAligned(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := KittyPlaceholdersAligned(buf.Bytes(), 2, CellSize{Width: 8, Height: 20})
+	if err != nil {
+		t.Fatalf("KittyPlaceholdersAligned: %v", err)
+	}
+	if !strings.Contains(got, ",r=1,c=3,") {
+		t.Errorf("Expected a placement one row by three columns, got %q", got)
+	}
+	if n := strings.Count(got, placeholder); n != 3 {
+		t.Errorf("Expected 3 placeholder cells, got %d", n)
+	}
+	if !strings.HasSuffix(got, "\x1b[39m ") {
+		t.Errorf("Expected inline placeholders to end the colour and be followed by a space, got %q", got)
+	}
+}
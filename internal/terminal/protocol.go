@@ -0,0 +1,109 @@
+// Package terminal provides terminal-specific functionality for DML
+package terminal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Protocol is a terminal graphics protocol that images are written in
+type Protocol string
+
+// The supported graphics protocols
+const (
+	ProtocolKitty Protocol = "kitty"
+	ProtocolSixel Protocol = "sixel"
+	ProtocolIterm Protocol = "iterm2"
+	ProtocolText  Protocol = "text" // No graphics: math is written as text
+)
+
+// Protocols lists the supported graphics protocols
+var Protocols = []Protocol{ProtocolKitty, ProtocolSixel, ProtocolIterm, ProtocolText}
+
+// protocol is the graphics protocol Inline and InlineAligned write
+var protocol = ProtocolKitty
+
+// SetProtocol selects the graphics protocol images are written in
+func SetProtocol(p Protocol) {
+	protocol = p
+}
+
+// CurrentProtocol returns the graphics protocol images are written in
+func CurrentProtocol() Protocol {
+	return protocol
+}
+
+// ProtocolNames returns the names of the supported graphics protocols
+func ProtocolNames() []string {
+	names := make([]string, len(Protocols))
+	for i, p := range Protocols {
+		names[i] = string(p)
+	}
+	return names
+}
+
+// ParseProtocol returns the graphics protocol with the given name. The
+// special name "auto" (or an empty name) detects the terminal's protocol.
+func ParseProtocol(name string) (Protocol, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == "auto" {
+		return DetectProtocol(), nil
+	}
+	for _, p := range Protocols {
+		if string(p) == name {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown graphics protocol '%s' (choose from auto, %s)", name, strings.Join(ProtocolNames(), ", "))
+}
+
+// Inline generates the terminal output for an image in the selected
+// protocol, sized like KittyInline: userTargetRows rows if set, otherwise
+// one row for inline math and the natural size for display math. Inside
+// tmux or GNU screen the graphics are wrapped to pass through.
+func Inline(img []byte, isDisplayMath bool, userTargetRows int) (string, error) {
+	s, err := inline(img, isDisplayMath, userTargetRows)
+	return Passthrough(s), err
+}
+
+// InlineAligned generates the terminal output for an inline math image in
+// the selected protocol with its baseline on the text baseline, like
+// KittyInlineAligned, wrapped to pass through tmux or GNU screen
+func InlineAligned(img []byte, depth int, cell CellSize) (string, error) {
+	s, err := inlineAligned(img, depth, cell)
+	return Passthrough(s), err
+}
+
+// inline writes an image in the selected protocol
+func inline(img []byte, isDisplayMath bool, userTargetRows int) (string, error) {
+	switch protocol {
+	case ProtocolSixel:
+		return SixelInline(img, isDisplayMath, userTargetRows)
+	case ProtocolIterm:
+		return ItermInline(img, isDisplayMath, userTargetRows)
+	case ProtocolText:
+		return "", fmt.Errorf("no graphics protocol")
+	default:
+		if usePlaceholders {
+			return KittyPlaceholders(img, isDisplayMath, userTargetRows)
+		}
+		return KittyInline(img, isDisplayMath, userTargetRows)
+	}
+}
+
+// inlineAligned writes an inline math image in the selected protocol
+func inlineAligned(img []byte, depth int, cell CellSize) (string, error) {
+	switch protocol {
+	case ProtocolSixel:
+		return SixelInlineAligned(img, depth, cell)
+	case ProtocolIterm:
+		return ItermInlineAligned(img, depth, cell)
+	case ProtocolText:
+		return "", fmt.Errorf("no graphics protocol")
+	default:
+		if usePlaceholders {
+			return KittyPlaceholdersAligned(img, depth, cell)
+		}
+		return KittyInlineAligned(img, depth, cell)
+	}
+}
@@ -0,0 +1,42 @@
+package terminal
+
+import (
+	"testing"
+)
+
+func TestParseProtocol(t *testing.T) {
+	for _, name := range []string{"kitty", "Sixel", " sixel ", "iterm2", "text"} {
+		if _, err := ParseProtocol(name); err != nil {
+			t.Errorf("ParseProtocol(%q): %v", name, err)
+		}
+	}
+	if _, err := ParseProtocol("vt100"); err == nil {
+		t.Errorf("Expected an error for an unknown protocol")
+	}
+}
+
+func TestChooseProtocol(t *testing.T) {
+	tests := []struct {
+		name        string
+		reply       string
+		termProgram string
+		termName    string
+		want        Protocol
+	}{
+		{"Kitty answers the graphics query", "\x1b_Gi=31;OK\x1b\\\x1b[?62;22c", "", "xterm-kitty", ProtocolKitty},
+		{"Graphics query error", "\x1b_Gi=31;EINVAL:bad\x1b\\\x1b[?62;22c", "", "xterm-256color", ProtocolText},
+		{"iTerm2", "\x1b[?62;22c", "iTerm.app", "xterm-256color", ProtocolIterm},
+		{"Sixel in DA1", "\x1b[?62;4;6;22c", "", "xterm-256color", ProtocolSixel},
+		{"No Sixel in DA1", "\x1b[?64;1;2;6;22c", "", "xterm-256color", ProtocolText},
+		{"Silent kitty", "", "", "xterm-kitty", ProtocolKitty},
+		{"Silent foot", "", "", "foot-extra", ProtocolSixel},
+		{"Unknown terminal", "", "", "dumb", ProtocolText},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := chooseProtocol([]byte(test.reply), test.termProgram, test.termName); got != test.want {
+				t.Errorf("chooseProtocol(%q, %q, %q) = %s, want %s", test.reply, test.termProgram, test.termName, got, test.want)
+			}
+		})
+	}
+}
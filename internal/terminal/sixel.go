@@ -0,0 +1,420 @@
+// Package terminal provides terminal-specific functionality for DML
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sixelColours is the number of colour registers a Sixel image may use;
+// terminals with Sixel support provide at least 256
+const sixelColours = 256
+
+// sixelMinAlpha is the opacity below which a pixel is left unpainted so
+// that the terminal background shows through
+const sixelMinAlpha = 8
+
+// SixelInline generates the Sixel graphics string for the given image
+// bytes. Sixel images are drawn at their size in pixels, so the image is
+// scaled here to the rows KittyInline would give it: userTargetRows if set,
+// otherwise one row for inline math and its natural size for display math.
+// Inline math needs the cell size to be known.
+func SixelInline(img []byte, isDisplayMath bool, userTargetRows int) (string, error) {
+	src, err := png.Decode(bytes.NewReader(img))
+	if err != nil {
+		return "", fmt.Errorf("decoding math PNG: %v", err)
+	}
+	canvas := toNRGBA(src)
+
+	rows := userTargetRows
+	if rows <= 0 && !isDisplayMath {
+		rows = 1
+	}
+	cell, cellErr := CachedCellSize()
+	if cellErr != nil && !isDisplayMath {
+		return "", fmt.Errorf("placing inline Sixel image: %v", cellErr)
+	}
+	if rows > 0 && cellErr == nil {
+		canvas = scaleToHeight(canvas, rows*cell.Height)
+	}
+
+	sixel := encodeSixel(canvas, terminalBackground())
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Generated Sixel image: %dx%d px, rows=%d, isDisplay=%v\n",
+			canvas.Bounds().Dx(), canvas.Bounds().Dy(), rows, isDisplayMath)
+	}
+
+	if isDisplayMath {
+		return sixel + "\n", nil
+	}
+	return sixelInlinePlacement(sixel, canvas.Bounds().Dx(), canvas.Bounds().Dy(), cell), nil
+}
+
+// SixelInlineAligned generates the Sixel graphics string for an inline math
+// image whose baseline lies depth pixels above its bottom edge, padded and
+// scaled to one row so its baseline lands on the text baseline as with
+// KittyInlineAligned
+func SixelInlineAligned(img []byte, depth int, cell CellSize) (string, error) {
+	if !cell.Valid() {
+		return "", fmt.Errorf("cell size unknown")
+	}
+	src, err := png.Decode(bytes.NewReader(img))
+	if err != nil {
+		return "", fmt.Errorf("decoding inline math PNG: %v", err)
+	}
+	if height := src.Bounds().Dy(); depth < 0 || depth > height {
+		return "", fmt.Errorf("baseline depth %d outside image of height %d", depth, height)
+	}
+
+	canvas := scaleToHeight(baselineCanvas(src, depth, cell), cell.Height)
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Aligned inline Sixel math: %dx%d px, depth %d, cell %dx%d\n",
+			canvas.Bounds().Dx(), canvas.Bounds().Dy(), depth, cell.Width, cell.Height)
+	}
+	return sixelInlinePlacement(encodeSixel(canvas, terminalBackground()), canvas.Bounds().Dx(), canvas.Bounds().Dy(), cell), nil
+}
+
+// sixelInlinePlacement keeps an inline Sixel image of the given size on the
+// current row. After drawing an image terminals move the cursor below it,
+// so the cursor is saved and restored around it and then moved past the
+// columns it covers. The saved position is absolute, and on the bottom row,
+// where streamed output is, moving below the image would scroll the screen
+// and leave it one line too low. So the lines below are made first, by
+// moving down and back up with IND and RI, which keep the column.
+func sixelInlinePlacement(sixel string, width, height int, cell CellSize) string {
+	cols := (width + cell.Width - 1) / cell.Width
+	if cols < 1 {
+		cols = 1
+	}
+	rows := (height + cell.Height - 1) / cell.Height
+	if rows < 1 {
+		rows = 1
+	}
+	makeRoom := strings.Repeat("\x1bD", rows) + strings.Repeat("\x1bM", rows)
+	return fmt.Sprintf("%s\x1b7%s\x1b8\x1b[%dC ", makeRoom, sixel, cols)
+}
+
+// encodeSixel encodes img as a Sixel image. Pixels that are partly
+// transparent, such as the anti-aliased edges of glyphs, are blended onto
+// the background colour bg; fully transparent pixels are not painted at all,
+// so the terminal's own background shows through. Images with more colours
+// than there are colour registers are quantised with median cut.
+func encodeSixel(img *image.NRGBA, bg color.RGBA) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Blend onto the background and count the colours used
+	pixels := make([]int32, width*height) // RGB, or -1 if unpainted
+	counts := make(map[uint32]int)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := img.NRGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+			if c.A < sixelMinAlpha {
+				pixels[y*width+x] = -1
+				continue
+			}
+			rgb := uint32(blend(c.R, bg.R, c.A))<<16 | uint32(blend(c.G, bg.G, c.A))<<8 | uint32(blend(c.B, bg.B, c.A))
+			pixels[y*width+x] = int32(rgb)
+			counts[rgb]++
+		}
+	}
+
+	palette := quantise(counts, sixelColours)
+	registers := make(map[uint32]int, len(counts))
+	for rgb := range counts {
+		registers[rgb] = nearestColour(palette, rgb)
+	}
+
+	var sb strings.Builder
+	// P2=1 leaves unpainted pixels as they are; the raster attributes give
+	// a 1:1 pixel aspect ratio and the image size
+	fmt.Fprintf(&sb, "\x1bP0;1;0q\"1;1;%d;%d", width, height)
+	for i, rgb := range palette {
+		fmt.Fprintf(&sb, "#%d;2;%d;%d;%d", i, percent(rgb>>16), percent(rgb>>8), percent(rgb))
+	}
+
+	// Each band of six rows is drawn once per colour it uses
+	for top := 0; top < height; top += 6 {
+		band := make(map[int][]byte)
+		for y := top; y < top+6 && y < height; y++ {
+			for x := 0; x < width; x++ {
+				p := pixels[y*width+x]
+				if p < 0 {
+					continue
+				}
+				reg := registers[uint32(p)]
+				if band[reg] == nil {
+					band[reg] = make([]byte, width)
+				}
+				band[reg][x] |= 1 << (y - top)
+			}
+		}
+
+		regs := make([]int, 0, len(band))
+		for reg := range band {
+			regs = append(regs, reg)
+		}
+		sort.Ints(regs)
+		for i, reg := range regs {
+			if i > 0 {
+				sb.WriteByte('$') // Back to the start of the band
+			}
+			sb.WriteString("#" + strconv.Itoa(reg))
+			writeSixelRow(&sb, band[reg])
+		}
+		if top+6 < height {
+			sb.WriteByte('-') // Next band
+		}
+	}
+
+	sb.WriteString("\x1b\\")
+	return sb.String()
+}
+
+// writeSixelRow writes one colour's sixels for a band, run-length encoded,
+// leaving out the empty sixels at the end
+func writeSixelRow(sb *strings.Builder, bits []byte) {
+	end := len(bits)
+	for end > 0 && bits[end-1] == 0 {
+		end--
+	}
+	for i := 0; i < end; {
+		run := 1
+		for i+run < end && bits[i+run] == bits[i] {
+			run++
+		}
+		ch := string(rune('?' + bits[i]))
+		if run > 3 {
+			sb.WriteString("!" + strconv.Itoa(run) + ch)
+		} else {
+			sb.WriteString(strings.Repeat(ch, run))
+		}
+		i += run
+	}
+}
+
+// blend composites a colour channel with opacity alpha onto a background
+func blend(fg, bg, alpha uint8) uint8 {
+	return uint8((int(fg)*int(alpha) + int(bg)*(255-int(alpha)) + 127) / 255)
+}
+
+// percent converts the low byte of v to the 0-100 scale Sixel colours use
+func percent(v uint32) int {
+	return int(math.Round(float64(v&0xff) * 100 / 255))
+}
+
+// colourCount is a colour and the number of pixels that have it
+type colourCount struct {
+	rgb   uint32
+	count int
+}
+
+// quantise picks at most n colours to represent counts. If there are few
+// enough colours they are used exactly; otherwise the colours are split by
+// median cut, repeatedly halving the box with the widest spread along that
+// channel, and each box is represented by its pixel-weighted mean.
+func quantise(counts map[uint32]int, n int) []uint32 {
+	colours := make([]colourCount, 0, len(counts))
+	for rgb, count := range counts {
+		colours = append(colours, colourCount{rgb, count})
+	}
+	sort.Slice(colours, func(i, j int) bool { return colours[i].rgb < colours[j].rgb })
+
+	if len(colours) <= n {
+		palette := make([]uint32, len(colours))
+		for i, c := range colours {
+			palette[i] = c.rgb
+		}
+		return palette
+	}
+
+	boxes := [][]colourCount{colours}
+	for len(boxes) < n {
+		best, bestShift, bestSpread := -1, uint(0), 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if shift, spread := widestChannel(box); spread > bestSpread {
+				best, bestShift, bestSpread = i, shift, spread
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool {
+			return box[i].rgb>>bestShift&0xff < box[j].rgb>>bestShift&0xff
+		})
+		total := 0
+		for _, c := range box {
+			total += c.count
+		}
+		cut, seen := 1, box[0].count
+		for cut < len(box)-1 && seen*2 < total {
+			seen += box[cut].count
+			cut++
+		}
+		boxes[best] = box[:cut]
+		boxes = append(boxes, box[cut:])
+	}
+
+	palette := make([]uint32, len(boxes))
+	for i, box := range boxes {
+		var r, g, b, total int
+		for _, c := range box {
+			r += int(c.rgb>>16&0xff) * c.count
+			g += int(c.rgb>>8&0xff) * c.count
+			b += int(c.rgb&0xff) * c.count
+			total += c.count
+		}
+		palette[i] = uint32((r+total/2)/total)<<16 | uint32((g+total/2)/total)<<8 | uint32((b+total/2)/total)
+	}
+	return palette
+}
+
+// widestChannel returns the bit shift of the RGB channel along which the
+// colours in box spread furthest, and that spread
+func widestChannel(box []colourCount) (uint, int) {
+	bestShift, bestSpread := uint(0), -1
+	for _, shift := range []uint{16, 8, 0} {
+		lo, hi := 255, 0
+		for _, c := range box {
+			v := int(c.rgb >> shift & 0xff)
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+		if hi-lo > bestSpread {
+			bestShift, bestSpread = shift, hi-lo
+		}
+	}
+	return bestShift, bestSpread
+}
+
+// nearestColour returns the index of the palette colour closest to rgb
+func nearestColour(palette []uint32, rgb uint32) int {
+	best, bestDist := 0, math.MaxInt32
+	for i, p := range palette {
+		dr := int(p>>16&0xff) - int(rgb>>16&0xff)
+		dg := int(p>>8&0xff) - int(rgb>>8&0xff)
+		db := int(p&0xff) - int(rgb&0xff)
+		if dist := dr*dr + dg*dg + db*db; dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+// toNRGBA returns img as an NRGBA image with its origin at (0, 0)
+func toNRGBA(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+	return dst
+}
+
+// scaleToHeight scales img to the given height, keeping its aspect ratio
+func scaleToHeight(img *image.NRGBA, height int) *image.NRGBA {
+	bounds := img.Bounds()
+	if height <= 0 || bounds.Dy() == 0 || bounds.Dy() == height {
+		return img
+	}
+	width := int(math.Round(float64(bounds.Dx()) * float64(height) / float64(bounds.Dy())))
+	if width < 1 {
+		width = 1
+	}
+	return scaleNRGBA(img, width, height)
+}
+
+// scaleNRGBA resizes img to width x height by averaging the source pixels
+// under each destination pixel. Colours are weighted by opacity so that
+// transparent pixels don't darken the edges they border.
+func scaleNRGBA(img *image.NRGBA, width, height int) *image.NRGBA {
+	bounds := img.Bounds()
+	sx := float64(bounds.Dx()) / float64(width)
+	sy := float64(bounds.Dy()) / float64(height)
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := float64(y)*sy, float64(y+1)*sy
+		for x := 0; x < width; x++ {
+			x0, x1 := float64(x)*sx, float64(x+1)*sx
+			var r, g, b, a, area float64
+			for py := int(y0); float64(py) < y1 && py < bounds.Dy(); py++ {
+				wy := math.Min(y1, float64(py+1)) - math.Max(y0, float64(py))
+				for px := int(x0); float64(px) < x1 && px < bounds.Dx(); px++ {
+					w := wy * (math.Min(x1, float64(px+1)) - math.Max(x0, float64(px)))
+					c := img.NRGBAAt(bounds.Min.X+px, bounds.Min.Y+py)
+					wa := w * float64(c.A)
+					r += wa * float64(c.R)
+					g += wa * float64(c.G)
+					b += wa * float64(c.B)
+					a += wa
+					area += w
+				}
+			}
+			if a > 0 {
+				dst.SetNRGBA(x, y, color.NRGBA{
+					R: uint8(r/a + 0.5),
+					G: uint8(g/a + 0.5),
+					B: uint8(b/a + 0.5),
+					A: uint8(a/area + 0.5),
+				})
+			}
+		}
+	}
+	return dst
+}
+
+// Reply to OSC 11, the background colour query, e.g. "rgb:1e1e/1e1e/2e2e"
+var backgroundReply = regexp.MustCompile(`\x1b\]11;rgb:([0-9a-fA-F]{1,4})/([0-9a-fA-F]{1,4})/([0-9a-fA-F]{1,4})(?:\x07|\x1b\\)`)
+
+// background is the terminal's background colour, which anti-aliased edges
+// are blended onto; black unless SetBackground says otherwise
+var background = color.RGBA{A: 255}
+
+// QueryBackground asks the terminal for its background colour with OSC 11
+func QueryBackground() (color.RGBA, error) {
+	reply, err := queryTTY("\x1b]11;?\x1b\\", replyMatches(backgroundReply))
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	m := backgroundReply.FindSubmatch(reply)
+	if m == nil {
+		return color.RGBA{}, fmt.Errorf("unrecognised background colour reply %q", reply)
+	}
+	return color.RGBA{R: hexChannel(m[1]), G: hexChannel(m[2]), B: hexChannel(m[3]), A: 255}, nil
+}
+
+// SetBackground sets the background colour Sixel images are blended onto.
+// Like SetCellSize it is called once, before rendering starts.
+func SetBackground(c color.RGBA) {
+	background = c
+}
+
+// terminalBackground returns the colour set with SetBackground
+func terminalBackground() color.RGBA {
+	return background
+}
+
+// hexChannel scales a 1-4 digit hexadecimal colour channel to 8 bits
+func hexChannel(b []byte) uint8 {
+	v, _ := strconv.ParseUint(string(b), 16, 16)
+	max := uint64(1)<<(4*uint(len(b))) - 1
+	return uint8((v*255 + max/2) / max)
+}
@@ -0,0 +1,125 @@
+package terminal
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestEncodeSixel(t *testing.T) {
+	black := color.RGBA{A: 255}
+
+	// An opaque red pixel beside a transparent one, which is left unpainted
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	want := "\x1bP0;1;0q\"1;1;2;1#0;2;100;0;0#0@\x1b\\"
+	if got := encodeSixel(img, black); got != want {
+		t.Errorf("encodeSixel = %q, want %q", got, want)
+	}
+
+	// A half-covered white edge pixel is blended onto the background
+	img = image.NewNRGBA(image.Rect(0, 0, 1, 7))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	img.SetNRGBA(0, 6, color.NRGBA{R: 255, G: 255, B: 255, A: 128})
+	want = "\x1bP0;1;0q\"1;1;1;7#0;2;50;50;50#1;2;100;100;100#1@-#0@\x1b\\"
+	if got := encodeSixel(img, black); got != want {
+		t.Errorf("encodeSixel with anti-aliasing = %q, want %q", got, want)
+	}
+
+	// Runs of more than three identical sixels are run-length encoded
+	img = image.NewNRGBA(image.Rect(0, 0, 5, 1))
+	for x := 0; x < 5; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{B: 255, A: 255})
+	}
+	if got := encodeSixel(img, black); !strings.Contains(got, "#0!5@") {
+		t.Errorf("encodeSixel of a run = %q, want it run-length encoded", got)
+	}
+}
+
+func TestQuantise(t *testing.T) {
+	counts := make(map[uint32]int)
+	for i := 0; i < 1000; i++ {
+		counts[uint32(i*16411)&0xffffff] = i%7 + 1
+	}
+	palette := quantise(counts, sixelColours)
+	if len(palette) != sixelColours {
+		t.Fatalf("quantise gave %d colours, want %d", len(palette), sixelColours)
+	}
+
+	// Few enough colours are kept exactly
+	exact := map[uint32]int{0xff0000: 3, 0x000000: 1}
+	if got := quantise(exact, sixelColours); len(got) != 2 || got[0] != 0x000000 || got[1] != 0xff0000 {
+		t.Errorf("quantise of two colours = %x, want them unchanged", got)
+	}
+}
+
+func TestScaleToHeight(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		img.SetNRGBA(0, y, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+		img.SetNRGBA(1, y, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	}
+
+	scaled := scaleToHeight(img, 2)
+	if b := scaled.Bounds(); b.Dx() != 2 || b.Dy() != 2 {
+		t.Fatalf("scaleToHeight(4x4, 2) is %dx%d, want 2x2", b.Dx(), b.Dy())
+	}
+	if c := scaled.NRGBAAt(0, 0); c != (color.NRGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("Opaque area scaled to %v", c)
+	}
+	if c := scaled.NRGBAAt(1, 0); c.A != 0 {
+		t.Errorf("Transparent area scaled to %v", c)
+	}
+
+	// Colour is kept at a transparent edge rather than darkened
+	img = image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	if c := scaleNRGBA(img, 1, 1).NRGBAAt(0, 0); c != (color.NRGBA{R: 255, G: 255, B: 255, A: 128}) {
+		t.Errorf("Edge scaled to %v, want half-transparent white", c)
+	}
+}
+
+func TestSixelInlineAligned(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 10))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := SixelInlineAligned(buf.Bytes(), 2, CellSize{Width: 8, Height: 20})
+	if err != nil {
+		t.Fatalf("SixelInlineAligned: %v", err)
+	}
+	// A line is made below the row first, so drawing on the bottom row
+	// can't scroll the saved cursor position out of place
+	if !strings.HasPrefix(got, "\x1bD\x1bM\x1b7\x1bP") || !strings.HasSuffix(got, "\x1b\\\x1b8\x1b[1C ") {
+		t.Errorf("Expected room below, then the image between cursor save and restore, then one column, got %q", got)
+	}
+	if !strings.Contains(got, "\"1;1;8;20") {
+		t.Errorf("Expected an image one cell tall, got %q", got)
+	}
+
+	if _, err := SixelInlineAligned(buf.Bytes(), 2, CellSize{}); err == nil {
+		t.Errorf("Expected an error for an unknown cell size")
+	}
+}
+
+func TestHexChannel(t *testing.T) {
+	tests := []struct {
+		hex  string
+		want uint8
+	}{
+		{"f", 255},
+		{"80", 128},
+		{"1e1e", 30},
+		{"0000", 0},
+	}
+	for _, test := range tests {
+		if got := hexChannel([]byte(test.hex)); got != test.want {
+			t.Errorf("hexChannel(%q) = %d, want %d", test.hex, got, test.want)
+		}
+	}
+}
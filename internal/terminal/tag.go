@@ -0,0 +1,59 @@
+// Package terminal provides terminal-specific functionality for DML
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"unicode/utf8"
+)
+
+// DefaultColumns is the terminal width assumed when it can't be determined
+const DefaultColumns = 80
+
+// Columns returns the width of the terminal in character cells, from
+// TIOCGWINSZ or $COLUMNS, or DefaultColumns
+func Columns() int {
+	if cols, _, _, _, err := windowSize(); err == nil && cols > 0 {
+		return cols
+	}
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		return cols
+	}
+	return DefaultColumns
+}
+
+// ImageRows returns how many terminal rows a display image of the given
+// pixel height occupies: userRows if it was sized explicitly, otherwise its
+// natural height in cells. It is 1 if the cell size is unknown.
+func ImageRows(height, userRows int) int {
+	if userRows > 0 {
+		return userRows
+	}
+	cell, err := CachedCellSize()
+	if err != nil || !cell.Valid() || height <= 0 {
+		return 1
+	}
+	return (height + cell.Height - 1) / cell.Height
+}
+
+// EquationTag returns the escape sequences that print tag, such as "(3)",
+// right-aligned in a terminal cols wide on the middle row of the display
+// image that was just written over rows rows. The cursor must be at the
+// start of the line below the image and is returned there.
+func EquationTag(tag string, rows, cols int) string {
+	if rows < 1 {
+		rows = 1
+	}
+	up := rows - (rows-1)/2
+	col := cols - utf8.RuneCountInString(tag) + 1
+	if col < 1 {
+		col = 1
+	}
+
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Equation tag %s at column %d, %d rows up\n", tag, col, up)
+	}
+	// Cursor up, to the column, the tag, then back down to column 1
+	return fmt.Sprintf("\x1b[%dA\x1b[%dG%s\x1b[%dB\r", up, col, tag, up)
+}
@@ -0,0 +1,37 @@
+package terminal
+
+import (
+	"testing"
+)
+
+func TestEquationTag(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  string
+		rows int
+		cols int
+		want string
+	}{
+		{"One row", "(1)", 1, 80, "\x1b[1A\x1b[78G(1)\x1b[1B\r"},
+		{"Middle of three rows", "(12)", 3, 80, "\x1b[2A\x1b[77G(12)\x1b[2B\r"},
+		{"Upper middle of four rows", "(2)", 4, 40, "\x1b[3A\x1b[38G(2)\x1b[3B\r"},
+		{"Wider than the terminal", "(1.2.3)", 1, 4, "\x1b[1A\x1b[1G(1.2.3)\x1b[1B\r"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := EquationTag(test.tag, test.rows, test.cols); got != test.want {
+				t.Errorf("EquationTag(%q, %d, %d) = %q, want %q", test.tag, test.rows, test.cols, got, test.want)
+			}
+		})
+	}
+}
+
+func TestImageRows(t *testing.T) {
+	if got := ImageRows(100, 3); got != 3 {
+		t.Errorf("ImageRows with explicit rows = %d, want 3", got)
+	}
+	if got := ImageRows(0, 0); got != 1 {
+		t.Errorf("ImageRows of empty image = %d, want 1", got)
+	}
+}
@@ -0,0 +1,60 @@
+// Package terminal provides terminal-specific functionality for DML
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// queryTimeout bounds how long we wait for the terminal to answer a query
+const queryTimeout = 200 * time.Millisecond
+
+// queryTTY writes query to the controlling terminal and collects the reply
+// until complete reports that it has been fully received. The terminal is
+// put in raw mode for the duration so the reply is neither echoed nor
+// line-buffered. Terminals that don't understand the query simply never
+// answer, so running out of time is reported as an error.
+func queryTTY(query string, complete func([]byte) bool) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no controlling terminal: %v", err)
+	}
+	defer tty.Close()
+
+	fd := int(tty.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("could not switch terminal to raw mode: %v", err)
+	}
+	defer term.Restore(fd, oldState)
+
+	// Without a deadline a silent terminal would block us forever
+	if err := tty.SetReadDeadline(time.Now().Add(queryTimeout)); err != nil {
+		return nil, fmt.Errorf("terminal does not support read deadlines: %v", err)
+	}
+
+	if _, err := tty.WriteString(query); err != nil {
+		return nil, err
+	}
+
+	var reply []byte
+	buf := make([]byte, 256)
+	for !complete(reply) {
+		n, err := tty.Read(buf)
+		reply = append(reply, buf[:n]...)
+		if err != nil {
+			if os.IsTimeout(err) {
+				return reply, fmt.Errorf("timed out waiting for terminal reply to %q", query)
+			}
+			return reply, err
+		}
+	}
+
+	if isDebug {
+		fmt.Fprintf(os.Stderr, "DEBUG: Terminal replied %q to query %q\n", reply, query)
+	}
+	return reply, nil
+}
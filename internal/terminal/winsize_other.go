@@ -0,0 +1,13 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+// Package terminal provides terminal-specific functionality for DML
+package terminal
+
+import (
+	"fmt"
+)
+
+// windowSize is unavailable on platforms without TIOCGWINSZ
+func windowSize() (cols, rows, xpix, ypix int, err error) {
+	return 0, 0, 0, 0, fmt.Errorf("TIOCGWINSZ is not supported on this platform")
+}
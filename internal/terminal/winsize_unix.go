@@ -0,0 +1,29 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+// Package terminal provides terminal-specific functionality for DML
+package terminal
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// windowSize returns the terminal grid and pixel size reported by TIOCGWINSZ,
+// trying stdout, stderr, stdin and finally the controlling terminal
+func windowSize() (cols, rows, xpix, ypix int, err error) {
+	fds := []uintptr{os.Stdout.Fd(), os.Stderr.Fd(), os.Stdin.Fd()}
+	if tty, openErr := os.Open("/dev/tty"); openErr == nil {
+		defer tty.Close()
+		fds = append(fds, tty.Fd())
+	}
+
+	for _, fd := range fds {
+		ws, wsErr := unix.IoctlGetWinsize(int(fd), unix.TIOCGWINSZ)
+		if wsErr == nil && ws.Col > 0 && ws.Row > 0 {
+			return int(ws.Col), int(ws.Row), int(ws.Xpixel), int(ws.Ypixel), nil
+		}
+	}
+	return 0, 0, 0, 0, fmt.Errorf("no terminal found for TIOCGWINSZ")
+}
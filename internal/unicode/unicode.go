@@ -0,0 +1,237 @@
+// Package unicode provides a Unicode fast path for simple LaTeX math expressions
+package unicode
+
+import (
+	"strings"
+)
+
+// An expression is "simple" when every token in it is one of:
+//   - an ASCII letter, digit, space or plain operator/punctuation character
+//   - a control word or symbol listed in the tables below
+//   - a ^ or _ applied to a single character, or to a braced group, where every
+//     character has a Unicode superscript/subscript form (plus ^\prime and ^\circ)
+//
+// Anything else (\frac, \sqrt, \left, environments, font commands, unknown
+// macros, nested scripts, stray braces) falls back to the LaTeX pipeline.
+
+// symbols maps control words to their Unicode equivalents
+var symbols = map[string]string{
+	// Lowercase Greek
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ",
+	"varepsilon": "ε", "zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ",
+	"iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ",
+	"pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ", "sigma": "σ",
+	"varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ", "varphi": "φ",
+	"chi": "χ", "psi": "ψ", "omega": "ω",
+
+	// Uppercase Greek
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ",
+	"Pi": "Π", "Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ",
+	"Omega": "Ω",
+
+	// Relations
+	"le": "≤", "leq": "≤", "ge": "≥", "geq": "≥", "ne": "≠", "neq": "≠",
+	"approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅",
+	"propto": "∝", "ll": "≪", "gg": "≫", "in": "∈", "notin": "∉", "ni": "∋",
+	"subset": "⊂", "subseteq": "⊆", "supset": "⊃", "supseteq": "⊇",
+	"perp": "⊥", "parallel": "∥", "mid": "∣",
+
+	// Binary operators
+	"times": "×", "cdot": "·", "div": "÷", "pm": "±", "mp": "∓",
+	"circ": "∘", "ast": "∗", "cup": "∪", "cap": "∩", "wedge": "∧",
+	"land": "∧", "vee": "∨", "lor": "∨", "oplus": "⊕", "otimes": "⊗",
+	"setminus": "∖",
+
+	// Arrows
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←",
+	"leftrightarrow": "↔", "Rightarrow": "⇒", "Leftarrow": "⇐",
+	"Leftrightarrow": "⇔", "implies": "⟹", "impliedby": "⟸", "iff": "⟺",
+	"mapsto": "↦", "uparrow": "↑", "downarrow": "↓",
+
+	// Miscellaneous symbols
+	"infty": "∞", "partial": "∂", "nabla": "∇", "forall": "∀",
+	"exists": "∃", "nexists": "∄", "neg": "¬", "lnot": "¬",
+	"emptyset": "∅", "varnothing": "∅", "hbar": "ℏ", "ell": "ℓ",
+	"angle": "∠", "prime": "′", "ldots": "…", "dots": "…", "cdots": "⋯",
+	"sum": "∑", "prod": "∏", "int": "∫", "oint": "∮",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋",
+	"lceil": "⌈", "rceil": "⌉",
+
+	// Named functions render upright, exactly like their names
+	"sin": "sin", "cos": "cos", "tan": "tan", "cot": "cot", "sec": "sec",
+	"csc": "csc", "arcsin": "arcsin", "arccos": "arccos", "arctan": "arctan",
+	"sinh": "sinh", "cosh": "cosh", "tanh": "tanh", "log": "log", "ln": "ln",
+	"exp": "exp", "det": "det", "dim": "dim", "ker": "ker", "gcd": "gcd",
+	"max": "max", "min": "min", "deg": "deg",
+
+	// Spacing
+	"quad": "  ", "qquad": "    ",
+}
+
+// controlSymbols maps single-character control sequences such as \{ and \,
+var controlSymbols = map[byte]string{
+	'{': "{", '}': "}", '%': "%", '&': "&", '#': "#", '$': "$",
+	',': " ", ';': " ", ':': " ", '!': "", ' ': " ", '|': "‖",
+}
+
+// plainChars are non-alphanumeric ASCII characters passed through unchanged
+const plainChars = "+-=<>()[],.;:/!'|?"
+
+// superscripts maps characters to their Unicode superscript forms
+var superscripts = map[rune]string{
+	'0': "⁰", '1': "¹", '2': "²", '3': "³", '4': "⁴", '5': "⁵", '6': "⁶",
+	'7': "⁷", '8': "⁸", '9': "⁹", '+': "⁺", '-': "⁻", '=': "⁼", '(': "⁽",
+	')': "⁾", 'i': "ⁱ", 'n': "ⁿ", 'j': "ʲ", 'k': "ᵏ", 'm': "ᵐ", 'x': "ˣ",
+	'y': "ʸ", 'T': "ᵀ",
+}
+
+// subscripts maps characters to their Unicode subscript forms
+var subscripts = map[rune]string{
+	'0': "₀", '1': "₁", '2': "₂", '3': "₃", '4': "₄", '5': "₅", '6': "₆",
+	'7': "₇", '8': "₈", '9': "₉", '+': "₊", '-': "₋", '=': "₌", '(': "₍",
+	')': "₎", 'a': "ₐ", 'e': "ₑ", 'h': "ₕ", 'i': "ᵢ", 'j': "ⱼ", 'k': "ₖ",
+	'l': "ₗ", 'm': "ₘ", 'n': "ₙ", 'o': "ₒ", 'p': "ₚ", 'r': "ᵣ", 's': "ₛ",
+	't': "ₜ", 'u': "ᵤ", 'v': "ᵥ", 'x': "ₓ",
+}
+
+// scriptSymbols are control words allowed as a whole superscript (x^\prime)
+var scriptSymbols = map[string]string{
+	"prime": "′", "circ": "°",
+}
+
+// Translate converts a simple LaTeX math expression into Unicode text.
+// It returns false if the expression is not simple enough to be rendered
+// faithfully without LaTeX, in which case the caller should render an image.
+func Translate(expr string) (string, bool) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return "", false
+	}
+
+	var sb strings.Builder
+	lastWasSpace := false
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			// TeX ignores spaces in math; keep at most one for readability
+			if !lastWasSpace {
+				sb.WriteByte(' ')
+				lastWasSpace = true
+			}
+			i++
+			continue
+
+		case isASCIILetter(c) || isDigit(c) || strings.IndexByte(plainChars, c) >= 0:
+			sb.WriteByte(c)
+			i++
+
+		case c == '\\':
+			text, n, ok := translateCommand(expr[i:])
+			if !ok {
+				return "", false
+			}
+			sb.WriteString(text)
+			i += n
+
+		case c == '^' || c == '_':
+			table := superscripts
+			if c == '_' {
+				table = subscripts
+			}
+			text, n, ok := translateScript(expr[i+1:], table, c == '^')
+			if !ok {
+				return "", false
+			}
+			sb.WriteString(text)
+			i += 1 + n
+
+		default:
+			// Braces, &, ~, $, non-ASCII input, etc.
+			return "", false
+		}
+		lastWasSpace = false
+	}
+
+	return strings.TrimSpace(sb.String()), true
+}
+
+// translateCommand translates the control sequence at the start of s,
+// returning the text and the number of bytes consumed
+func translateCommand(s string) (string, int, bool) {
+	if len(s) < 2 {
+		return "", 0, false
+	}
+	if !isASCIILetter(s[1]) {
+		text, ok := controlSymbols[s[1]]
+		return text, 2, ok
+	}
+	name := commandName(s)
+	text, ok := symbols[name]
+	return text, 1 + len(name), ok
+}
+
+// translateScript translates the argument of ^ or _ found at the start of s
+func translateScript(s string, table map[rune]string, isSuper bool) (string, int, bool) {
+	if s == "" {
+		return "", 0, false
+	}
+
+	var arg string
+	var consumed int
+	switch {
+	case s[0] == '{':
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", 0, false
+		}
+		arg = strings.TrimSpace(s[1:end])
+		consumed = end + 1
+	case s[0] == '\\':
+		name := commandName(s)
+		if !isSuper || name == "" {
+			return "", 0, false
+		}
+		text, ok := scriptSymbols[name]
+		return text, 1 + len(name), ok
+	default:
+		arg = s[:1]
+		consumed = 1
+	}
+
+	if arg == "" {
+		return "", 0, false
+	}
+	if isSuper {
+		if text, ok := scriptSymbols[strings.TrimPrefix(arg, `\`)]; ok && strings.HasPrefix(arg, `\`) {
+			return text, consumed, true
+		}
+	}
+
+	var sb strings.Builder
+	for _, r := range arg {
+		mapped, ok := table[r]
+		if !ok {
+			return "", 0, false
+		}
+		sb.WriteString(mapped)
+	}
+	return sb.String(), consumed, true
+}
+
+// commandName returns the letters of the control word starting at s[0] == '\\'
+func commandName(s string) string {
+	end := 1
+	for end < len(s) && isASCIILetter(s[end]) {
+		end++
+	}
+	return s[1:end]
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
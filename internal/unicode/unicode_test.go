@@ -0,0 +1,56 @@
+package unicode
+
+import (
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   string
+		wantOK bool
+	}{
+		// Simple expressions
+		{`\alpha`, "α", true},
+		{`x^2`, "x²", true},
+		{`n \le 10`, "n ≤ 10", true},
+		{`x^{-1}`, "x⁻¹", true},
+		{`a_i + b_{12}`, "aᵢ + b₁₂", true},
+		{`E=mc^2`, "E=mc²", true},
+		{`\Gamma(n) = (n-1)!`, "Γ(n) = (n-1)!", true},
+		{`f^\prime(x)`, "f′(x)", true},
+		{`90^\circ`, "90°", true},
+		{`A^T`, "Aᵀ", true},
+		{`x \in \mathbb`, "", false},
+		{`\sin x \to 0`, "sin x → 0", true},
+		{`\{1, 2\}`, "{1, 2}", true},
+		{`  x   +   y  `, "x + y", true},
+		{`a \cdot b \ne 0`, "a · b ≠ 0", true},
+
+		// Not simple enough
+		{``, "", false},
+		{`\frac{1}{2}`, "", false},
+		{`\sqrt{x}`, "", false},
+		{`x^{2^3}`, "", false},
+		{`x^q`, "", false},
+		{`x_{\alpha}`, "", false},
+		{`\mathbf{v}`, "", false},
+		{`\alphax`, "", false},
+		{`{x}`, "", false},
+		{`a & b`, "", false},
+		{`x^`, "", false},
+		{`x^{2`, "", false},
+		{`\begin{matrix} a \end{matrix}`, "", false},
+	}
+
+	for _, test := range tests {
+		got, ok := Translate(test.input)
+		if ok != test.wantOK {
+			t.Errorf("Translate(%q) ok = %v, want %v (got %q)", test.input, ok, test.wantOK, got)
+			continue
+		}
+		if ok && got != test.want {
+			t.Errorf("Translate(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}